@@ -0,0 +1,62 @@
+// Package api holds the names shared by the microblob server and its clients:
+// error classes and routes. It has no dependencies, so clients do not need to
+// import the server.
+package api
+
+import "errors"
+
+// Error classes, test errors with errors.Is.
+var (
+	// ErrNotFound if a key does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrCorrupt if an index entry or a document is damaged.
+	ErrCorrupt = errors.New("corrupt")
+	// ErrIO if the index or the blob file cannot be read.
+	ErrIO = errors.New("i/o error")
+	// ErrClosed if the backend has been closed.
+	ErrClosed = errors.New("backend closed")
+	// ErrReadOnly if a write is attempted on a backend opened read-only.
+	ErrReadOnly = errors.New("backend is read-only")
+)
+
+// Routes, other than the preferred lookup route /{key}.
+const (
+	RouteBlob    = "/blob"   // legacy lookup, the raw query is the key
+	RouteLookup  = "/lookup" // lookup, the key is passed as escaped key parameter
+	RouteCount   = "/count"
+	RouteExists  = "/exists"
+	RouteExport  = "/export"
+	RouteHealthz = "/healthz"
+	RouteHotKeys = "/debug/hotkeys"
+	RouteInfo    = "/info"
+	RouteKeys    = "/keys"
+	RouteMetrics = "/metrics"
+	RouteReadyz  = "/readyz"
+	RouteStats   = "/stats"
+	RouteUpdate  = "/update"
+	RouteVars    = "/debug/vars"
+)
+
+// routes are served by other handlers than lookups.
+var routes = map[string]bool{
+	RouteBlob:    true,
+	RouteLookup:  true,
+	RouteCount:   true,
+	RouteExists:  true,
+	RouteExport:  true,
+	RouteHealthz: true,
+	RouteHotKeys: true,
+	RouteInfo:    true,
+	RouteKeys:    true,
+	RouteMetrics: true,
+	RouteReadyz:  true,
+	RouteStats:   true,
+	RouteUpdate:  true,
+	RouteVars:    true,
+}
+
+// Reserved reports whether a key cannot be looked up as /{key}, because the
+// path belongs to another route. Such keys can be fetched via RouteLookup.
+func Reserved(key string) bool {
+	return routes["/"+key] || key == "blob/"
+}
@@ -0,0 +1,278 @@
+package microblob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope is a permission granted to a credential. The write scope includes
+// the read scope.
+type Scope int
+
+const (
+	ScopeRead Scope = iota + 1
+	ScopeWrite
+)
+
+// defaultMaxSkew is the maximum age of a signed request, if not configured.
+const defaultMaxSkew = 5 * time.Minute
+
+// hmacScheme is the authorization scheme for signed requests.
+const hmacScheme = "HMAC-SHA256"
+
+var (
+	errUnauthorized   = errors.New("authentication required")
+	errForbidden      = errors.New("insufficient scope")
+	errDigestMismatch = errors.New("body does not match X-Content-SHA256")
+	errReplayed       = errors.New("signature already used")
+)
+
+// Credential identifies a client. The secret is either sent as bearer token
+// or used to sign requests.
+type Credential struct {
+	ID     string
+	Secret string
+	Scope  Scope
+}
+
+// Auth protects routes with bearer tokens or HMAC signed requests. Write
+// routes always require a credential with write scope, read routes only
+// require a credential, if RequireRead is set. A nil Auth allows everything.
+//
+// A signed request carries a header:
+//
+//	Authorization: HMAC-SHA256 Credential=<id>, Timestamp=<unix>, Signature=<hex>
+//
+// The signature is the hex encoded HMAC-SHA256 of method, request URI,
+// timestamp and the hex encoded SHA-256 of the body (as sent in the
+// X-Content-SHA256 header, may be omitted for empty bodies), joined by
+// newlines. See SignRequest. Each signature is accepted only once, so a
+// captured request cannot be replayed while its timestamp is still valid.
+type Auth struct {
+	Credentials []Credential
+	RequireRead bool
+	MaxSkew     time.Duration // maximum age of signed requests, default five minutes
+
+	mu        sync.Mutex
+	seen      map[string]time.Time // signatures, until they expire
+	nextSweep time.Time
+}
+
+type contextKey int
+
+const (
+	identityKey contextKey = iota
+	accessRecordKey
+)
+
+// Identity returns the ID of the credential, that authenticated a request,
+// or the empty string.
+func Identity(r *http.Request) string {
+	id, _ := r.Context().Value(identityKey).(string)
+	return id
+}
+
+// ParseCredentials parses a comma separated list of id:secret pairs, as used
+// in the config file, e.g. "indexer:s3cr3t, backup:t0k3n".
+func ParseCredentials(s string, scope Scope) ([]Credential, error) {
+	var creds []Credential
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		parts := strings.SplitN(f, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("credential must be id:secret, got %q", f)
+		}
+		creds = append(creds, Credential{ID: parts[0], Secret: parts[1], Scope: scope})
+	}
+	return creds, nil
+}
+
+// Require wraps a handler, that needs the given scope.
+func (a *Auth) Require(scope Scope, h http.Handler) http.Handler {
+	if a == nil || (scope == ScopeRead && !a.RequireRead) {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cred, err := a.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="microblob"`)
+			writeError(w, http.StatusUnauthorized, "", err)
+			return
+		}
+		if cred.Scope < scope {
+			writeError(w, http.StatusForbidden, "", errForbidden)
+			return
+		}
+		noteIdentity(r, cred.ID)
+		ctx := context.WithValue(r.Context(), identityKey, cred.ID)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticate finds the credential for a request. For signed requests, the
+// body is replaced by a reader, which fails if the body does not match the
+// signed digest.
+func (a *Auth) authenticate(r *http.Request) (*Credential, error) {
+	header := r.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(header, "Bearer "):
+		return a.token(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	case strings.HasPrefix(header, hmacScheme+" "):
+		return a.verify(r, strings.TrimPrefix(header, hmacScheme+" "))
+	default:
+		return nil, errUnauthorized
+	}
+}
+
+// token finds the credential for a bearer token. All credentials are
+// compared, so the time taken does not depend on which one matches.
+func (a *Auth) token(token string) (*Credential, error) {
+	var found *Credential
+	for i := range a.Credentials {
+		c := &a.Credentials[i]
+		if subtle.ConstantTimeCompare([]byte(c.Secret), []byte(token)) == 1 {
+			found = c
+		}
+	}
+	if found == nil {
+		return nil, errUnauthorized
+	}
+	return found, nil
+}
+
+// verify checks the signature of a request.
+func (a *Auth) verify(r *http.Request, params string) (*Credential, error) {
+	var id, ts, sig string
+	for _, p := range strings.Split(params, ",") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) != 2 {
+			return nil, errUnauthorized
+		}
+		switch kv[0] {
+		case "Credential":
+			id = kv[1]
+		case "Timestamp":
+			ts = kv[1]
+		case "Signature":
+			sig = kv[1]
+		}
+	}
+	var cred *Credential
+	for i := range a.Credentials {
+		if a.Credentials[i].ID == id {
+			cred = &a.Credentials[i]
+			break
+		}
+	}
+	if cred == nil {
+		return nil, errUnauthorized
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, errUnauthorized
+	}
+	maxSkew := a.MaxSkew
+	if maxSkew == 0 {
+		maxSkew = defaultMaxSkew
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > maxSkew || skew < -maxSkew {
+		return nil, fmt.Errorf("signature expired")
+	}
+	digest := r.Header.Get("X-Content-SHA256")
+	if digest == "" {
+		digest = emptySHA256
+	}
+	want, err := hex.DecodeString(digest)
+	if err != nil {
+		return nil, errUnauthorized
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, signature(cred.Secret, r.Method, r.URL.RequestURI(), ts, digest)) {
+		return nil, errUnauthorized
+	}
+	if !a.remember(sig, time.Unix(unix, 0).Add(maxSkew)) {
+		return nil, errReplayed
+	}
+	r.Body = &digestReader{ReadCloser: r.Body, h: sha256.New(), want: want}
+	return cred, nil
+}
+
+// remember records a signature until it expires and reports whether it was
+// seen for the first time. Expired signatures are dropped about once per
+// expiry window, so the cache only holds recent signatures.
+func (a *Auth) remember(sig string, expires time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if a.seen == nil {
+		a.seen = make(map[string]time.Time)
+	}
+	if now.After(a.nextSweep) {
+		for k, t := range a.seen {
+			if now.After(t) {
+				delete(a.seen, k)
+			}
+		}
+		a.nextSweep = expires
+	}
+	sig = strings.ToLower(sig)
+	if _, ok := a.seen[sig]; ok {
+		return false
+	}
+	a.seen[sig] = expires
+	return true
+}
+
+// emptySHA256 is the digest of an empty body.
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// signature computes the signature of a request.
+func signature(secret, method, uri, ts, digest string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, strings.Join([]string{method, uri, ts, digest}, "\n"))
+	return mac.Sum(nil)
+}
+
+// SignRequest signs a request with a credential. The body must be the same as
+// the request body.
+func SignRequest(r *http.Request, cred Credential, body []byte) {
+	var (
+		sum    = sha256.Sum256(body)
+		digest = hex.EncodeToString(sum[:])
+		ts     = strconv.FormatInt(time.Now().Unix(), 10)
+		sig    = signature(cred.Secret, r.Method, r.URL.RequestURI(), ts, digest)
+	)
+	r.Header.Set("X-Content-SHA256", digest)
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s, Timestamp=%s, Signature=%x",
+		hmacScheme, cred.ID, ts, sig))
+}
+
+// digestReader fails at the end of the body, if it does not match the digest.
+type digestReader struct {
+	io.ReadCloser
+	h    hash.Hash
+	want []byte
+}
+
+func (r *digestReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.h.Write(p[:n])
+	if err == io.EOF && !hmac.Equal(r.h.Sum(nil), r.want) {
+		return n, errDigestMismatch
+	}
+	return n, err
+}
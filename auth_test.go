@@ -0,0 +1,33 @@
+package microblob
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSignedRequestReplay(t *testing.T) {
+	cred := Credential{ID: "indexer", Secret: "s3cr3t", Scope: ScopeWrite}
+	auth := &Auth{Credentials: []Credential{cred}}
+	h := auth.Require(ScopeWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	body := `{"id": "x"}` + "\n"
+	req := httptest.NewRequest("POST", "/update?key=id", strings.NewReader(body))
+	SignRequest(req, cred, []byte(body))
+	do := func() int {
+		r := httptest.NewRequest("POST", "/update?key=id", strings.NewReader(body))
+		r.Header = req.Header.Clone()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := do(); code != http.StatusOK {
+		t.Fatalf("signed request: got %d, want 200", code)
+	}
+	if code := do(); code != http.StatusUnauthorized {
+		t.Errorf("replayed request: got %d, want 401", code)
+	}
+}
@@ -2,23 +2,42 @@
 package microblob
 
 import (
+	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
+	"sync"
+	"time"
 
+	"github.com/segmentio/encoding/json"
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
-// ErrInvalidValue if a value is corrupted.
-var ErrInvalidValue = errors.New("invalid entry")
+// crcTable is used to checksum records, CRC-32C is hardware accelerated on most platforms.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
 
-// Entry associates a string key with a section in a file specified by offset and length.
+// Entry associates a string key with a section in a file specified by offset
+// and length. Checksum is the CRC-32C of the section, zero if unknown, e.g. in
+// databases created by earlier versions.
 type Entry struct {
-	Key    string `json:"k"`
-	Offset int64  `json:"o"`
-	Length int64  `json:"l"`
+	Key      string `json:"k"`
+	Offset   int64  `json:"o"`
+	Length   int64  `json:"l"`
+	Checksum uint32 `json:"c,omitempty"`
+}
+
+// ETag returns a strong entity tag for the section of the blob file this entry
+// points to. Entries with a checksum are tagged by content, older entries by
+// position, which is stable, since the blob file is append-only.
+func (e Entry) ETag() string {
+	if e.Checksum == 0 {
+		return fmt.Sprintf(`"%x.%x"`, e.Offset, e.Length)
+	}
+	return fmt.Sprintf(`"%x-%08x"`, e.Length, e.Checksum)
 }
 
 // Counter can return the number of elements.
@@ -26,6 +45,40 @@ type Counter interface {
 	Count() (int64, error)
 }
 
+// Lookuper can return the index entry for a key, without reading the blob.
+type Lookuper interface {
+	Lookup(key string) (Entry, error)
+}
+
+// EntryReader can read the section of the blob an entry points to.
+type EntryReader interface {
+	ReadEntry(entry Entry) ([]byte, error)
+}
+
+// RangeReader can read part of the section of the blob an entry points to.
+type RangeReader interface {
+	ReadEntryRange(entry Entry, start, length int64) ([]byte, error)
+}
+
+// ModTimer can report the time of the last modification of the stored data.
+type ModTimer interface {
+	ModTime() (time.Time, error)
+}
+
+// ScanOptions restrict a scan over the index.
+type ScanOptions struct {
+	Prefix string // only keys with this prefix
+	Start  string // first key to consider, e.g. the cursor of a previous scan
+	Limit  int    // maximum number of entries, zero means no limit
+}
+
+// Scanner can iterate over index entries in key order. Scan calls f for each
+// entry and returns a cursor, which can be used as Start to continue the scan;
+// the cursor is empty, if there are no more entries.
+type Scanner interface {
+	Scan(opts ScanOptions, f func(Entry) error) (next string, err error)
+}
+
 // Backend abstracts various implementations.
 type Backend interface {
 	Get(key string) ([]byte, error)
@@ -62,10 +115,19 @@ type LevelDBBackend struct {
 	Filename         string
 	db               *leveldb.DB
 	AllowEmptyValues bool
+	ReadOnly         bool   // open the database read-only, can be shared by processes
+	Extractor        string // key extractor, recorded when the database is created
+	closed           bool
+
+	metaMu sync.Mutex // protects meta and bulk
+	meta   *dbMeta
+	bulk   bool // initial build, stats are computed afterwards
 }
 
-// Close closes database handle and blob file.
+// Close closes database handle and blob file. Subsequent operations fail with
+// ErrClosed.
 func (b *LevelDBBackend) Close() error {
+	b.closed = true
 	if b.db != nil {
 		if err := b.db.Close(); err != nil {
 			return err
@@ -81,49 +143,199 @@ func (b *LevelDBBackend) Close() error {
 	return nil
 }
 
-// WriteEntries writes entries as batch into LevelDB. The value is fixed 16 byte
-// slice, first 8 bytes represents the offset, last 8 bytes the length.
+// WriteEntries writes entries as batch into LevelDB. The value is fixed 20 byte
+// slice, first 8 bytes represents the offset, next 8 bytes the length, last 4
+// bytes the checksum. Values written by earlier versions lack the checksum.
 // https://play.golang.org/p/xwX8BmWtVl
 func (b *LevelDBBackend) WriteEntries(entries []Entry) error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
 	if err := b.openDatabase(); err != nil {
 		return err
 	}
-	batch := new(leveldb.Batch)
+	b.metaMu.Lock()
+	defer b.metaMu.Unlock()
+	var (
+		batch   = new(leveldb.Batch)
+		current *indexStats
+		stats   indexStats
+		err     error
+	)
+	if !b.bulk {
+		if current, err = b.stats(); err != nil {
+			return err
+		}
+		stats = *current
+		if err := b.updateStats(&stats, entries); err != nil {
+			return err
+		}
+		data, err := json.Marshal(stats)
+		if err != nil {
+			return err
+		}
+		batch.Put([]byte(statsKey), data)
+	}
 	for _, entry := range entries {
-		value := make([]byte, 16)
+		if isReserved([]byte(entry.Key)) {
+			return &KeyError{Offset: entry.Offset, Err: fmt.Errorf("reserved key: %q", entry.Key)}
+		}
+		value := make([]byte, 20)
 		binary.PutVarint(value[:8], entry.Offset)
-		binary.PutVarint(value[8:], entry.Length)
+		binary.PutVarint(value[8:16], entry.Length)
+		binary.BigEndian.PutUint32(value[16:], entry.Checksum)
 		batch.Put([]byte(entry.Key), value)
 	}
-	return b.db.Write(batch, nil)
+	if err := b.db.Write(batch, nil); err != nil {
+		return classify(err)
+	}
+	if current != nil {
+		*current = stats
+	}
+	return nil
 }
 
-// Count returns the number of documents added. LevelDB says: There is no way
-// to implement Count more efficiently inside leveldb than outside.
-func (b *LevelDBBackend) Count() (n int64, err error) {
+// Lookup returns the index entry for a key.
+func (b *LevelDBBackend) Lookup(key string) (entry Entry, err error) {
 	if err = b.openDatabase(); err != nil {
+		return entry, err
+	}
+	if isReserved([]byte(key)) {
+		return entry, ErrNotFound
+	}
+	var value []byte
+	if value, err = b.db.Get([]byte(key), nil); err != nil {
+		return entry, classify(err)
+	}
+	return decodeEntry(key, value)
+}
+
+// Get retrieves the data for a given key.
+func (b *LevelDBBackend) Get(key string) ([]byte, error) {
+	entry, err := b.Lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	return b.ReadEntry(entry)
+}
+
+// ReadEntry reads the section of the blob file an entry points to. If the
+// entry has a checksum, the data is verified.
+func (b *LevelDBBackend) ReadEntry(entry Entry) (data []byte, err error) {
+	if err = b.openBlob(); err != nil {
+		return nil, err
+	}
+	data = make([]byte, entry.Length)
+	n, err := b.readAt(data, entry.Offset)
+	if !b.AllowEmptyValues && IsAllZero(data) {
+		return nil, &BackendError{Class: ErrCorrupt, Err: fmt.Errorf("empty value")}
+	}
+	if err == nil && int64(n) < entry.Length {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, classify(err)
+	}
+	if entry.Checksum != 0 && crc32.Checksum(data, crcTable) != entry.Checksum {
+		return nil, &BackendError{Class: ErrCorrupt, Err: fmt.Errorf("checksum mismatch at offset %d", entry.Offset)}
+	}
+	return data, nil
+}
+
+// ReadEntryRange reads length bytes, starting at start, from the section of
+// the blob file an entry points to, without reading the whole section. The
+// data cannot be verified against the checksum.
+func (b *LevelDBBackend) ReadEntryRange(entry Entry, start, length int64) ([]byte, error) {
+	if start < 0 || length < 0 || start+length > entry.Length {
+		return nil, fmt.Errorf("range %d+%d out of bounds for entry of length %d", start, length, entry.Length)
+	}
+	if err := b.openBlob(); err != nil {
+		return nil, err
+	}
+	data := make([]byte, length)
+	n, err := b.readAt(data, entry.Offset+start)
+	if err == nil && int64(n) < length {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, classify(err)
+	}
+	return data, nil
+}
+
+// ModTime returns the modification time of the blob file.
+func (b *LevelDBBackend) ModTime() (time.Time, error) {
+	fi, err := os.Stat(b.Blobfile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// Count returns the number of documents added. LevelDB says: There is no way
+// to implement Count more efficiently inside leveldb than outside. So the
+// count is taken once and then maintained with each write.
+func (b *LevelDBBackend) Count() (int64, error) {
+	if err := b.openDatabase(); err != nil {
 		return 0, err
 	}
-	iter := b.db.NewIterator(nil, nil)
+	b.metaMu.Lock()
+	defer b.metaMu.Unlock()
+	s, err := b.stats()
+	if err != nil {
+		return 0, err
+	}
+	return s.Keys, nil
+}
+
+// Scan iterates over index entries in key order.
+func (b *LevelDBBackend) Scan(opts ScanOptions, f func(Entry) error) (next string, err error) {
+	if err = b.openDatabase(); err != nil {
+		return "", err
+	}
+	var rng *util.Range
+	if opts.Prefix != "" {
+		rng = util.BytesPrefix([]byte(opts.Prefix))
+	}
+	iter := b.db.NewIterator(rng, nil)
 	defer iter.Release()
-	for iter.Next() {
+	var ok bool
+	if opts.Start != "" {
+		ok = iter.Seek([]byte(opts.Start))
+	} else {
+		ok = iter.First()
+	}
+	var n int
+	for ; ok; ok = iter.Next() {
+		if isReserved(iter.Key()) {
+			continue
+		}
+		if opts.Limit > 0 && n == opts.Limit {
+			return string(iter.Key()), nil
+		}
+		entry, err := decodeEntry(string(iter.Key()), iter.Value())
+		if err != nil {
+			return "", err
+		}
+		if err := f(entry); err != nil {
+			return "", err
+		}
 		n++
 	}
-	err = iter.Error()
-	return
+	return "", classify(iter.Error())
 }
 
 // openBlob opens the raw file. Save to call many times.
 func (b *LevelDBBackend) openBlob() error {
-	// TODO(miku): Store a SHA of the origin file in the blob store, compare with the
-	// SHA of the currently used blob file, so we can warn the user if database and
-	// file won't match.
+	if b.closed {
+		return ErrClosed
+	}
 	if b.blob != nil {
 		return nil
 	}
 	file, err := os.Open(b.Blobfile)
 	if err != nil {
-		return err
+		return classify(err)
 	}
 	b.blob = file
 	return nil
@@ -131,17 +343,49 @@ func (b *LevelDBBackend) openBlob() error {
 
 // openDatabase creates a LevelDB handle. Save to call many times.
 func (b *LevelDBBackend) openDatabase() error {
+	if b.closed {
+		return ErrClosed
+	}
 	if b.db != nil {
 		return nil
 	}
-	db, err := leveldb.OpenFile(b.Filename, nil)
+	_, err := os.Stat(b.Filename)
+	created := os.IsNotExist(err)
+	db, err := leveldb.OpenFile(b.Filename, &opt.Options{ReadOnly: b.ReadOnly})
 	if err != nil {
-		return err
+		return classify(err)
+	}
+	b.metaMu.Lock()
+	defer b.metaMu.Unlock()
+	if err := b.loadMeta(created); err != nil {
+		db.Close()
+		return classify(err)
 	}
 	b.db = db
 	return nil
 }
 
+// decodeEntry parses a LevelDB value into an entry.
+func decodeEntry(key string, value []byte) (entry Entry, err error) {
+	if len(value) < 16 {
+		return entry, ErrInvalidValue
+	}
+	entry.Key = key
+	if entry.Offset, err = binary.ReadVarint(bytes.NewBuffer(value[:8])); err != nil {
+		return entry, &BackendError{Class: ErrCorrupt, Err: err}
+	}
+	if entry.Length, err = binary.ReadVarint(bytes.NewBuffer(value[8:16])); err != nil {
+		return entry, &BackendError{Class: ErrCorrupt, Err: err}
+	}
+	if entry.Offset < 0 || entry.Length < 0 {
+		return entry, ErrInvalidValue
+	}
+	if len(value) >= 20 {
+		entry.Checksum = binary.BigEndian.Uint32(value[16:20])
+	}
+	return entry, nil
+}
+
 // IsAllZero returns true, if all bytes in a slice are zero.
 func IsAllZero(p []byte) bool {
 	for _, b := range p {
@@ -2,46 +2,9 @@
 
 package microblob
 
-import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
-	"syscall"
-)
+import "syscall"
 
-// Get retrieves the data for a given key, using pread(2).
-func (b *LevelDBBackend) Get(key string) (data []byte, err error) {
-	if err = b.openDatabase(); err != nil {
-		return nil, err
-	}
-
-	var value []byte
-	var offset, length int64
-
-	if value, err = b.db.Get([]byte(key), nil); err != nil {
-		return nil, err
-	}
-	if len(value) < 16 {
-		return nil, ErrInvalidValue
-	}
-	if offset, err = binary.ReadVarint(bytes.NewBuffer(value[:8])); err != nil {
-		return nil, err
-	}
-	if length, err = binary.ReadVarint(bytes.NewBuffer(value[8:])); err != nil {
-		return nil, err
-	}
-
-	if err = b.openBlob(); err != nil {
-		return nil, err
-	}
-
-	data = make([]byte, length)
-
-	_, err = syscall.Pread(int(b.blob.Fd()), data, offset)
-
-	if !b.AllowEmptyValues && IsAllZero(data) {
-		return nil, fmt.Errorf("empty value")
-	}
-
-	return data, err
+// readAt reads from the blob file at a given offset, using pread(2).
+func (b *LevelDBBackend) readAt(p []byte, offset int64) (int, error) {
+	return syscall.Pread(int(b.blob.Fd()), p, offset)
 }
@@ -3,17 +3,14 @@
 package microblob
 
 import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
 	"io"
 	"sync"
 )
 
-var mu sync.Mutex // Protects seek and read on systems without pread.
+var blobMu sync.Mutex // Protects seek and read on systems without pread.
 
-// Get retrieves the data for a given key.
-// Raw timings of the operations:
+// readAt reads from the blob file at a given offset.
+// Raw timings of the operations of a Get:
 // Cold:
 //     time.Now(): 89ns
 //     b.openDatabase(): 2.692719ms
@@ -31,46 +28,11 @@ var mu sync.Mutex // Protects seek and read on systems without pread.
 //     b.blob.Seek: 218.031µs
 //     b.blob.Read: 252.66µs
 //
-func (b *LevelDBBackend) Get(key string) (data []byte, err error) {
-	if err = b.openDatabase(); err != nil {
-		return nil, err
+func (b *LevelDBBackend) readAt(p []byte, offset int64) (int, error) {
+	blobMu.Lock()
+	defer blobMu.Unlock()
+	if _, err := b.blob.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
 	}
-
-	var value []byte
-	var offset, length int64
-
-	if value, err = b.db.Get([]byte(key), nil); err != nil {
-		return nil, err
-	}
-	if len(value) < 16 {
-		return nil, ErrInvalidValue
-	}
-	if offset, err = binary.ReadVarint(bytes.NewBuffer(value[:8])); err != nil {
-		return nil, err
-	}
-	if length, err = binary.ReadVarint(bytes.NewBuffer(value[8:])); err != nil {
-		return nil, err
-	}
-
-	if err = b.openBlob(); err != nil {
-		return nil, err
-	}
-
-	data = make([]byte, length)
-
-	mu.Lock()
-	defer mu.Unlock()
-
-	if _, err = b.blob.Seek(offset, io.SeekStart); err != nil {
-		return nil, err
-	}
-	if _, err = b.blob.Read(data); err != nil {
-		return nil, err
-	}
-
-	if !b.AllowEmptyValues && IsAllZero(data) {
-		return nil, fmt.Errorf("empty value")
-	}
-
-	return data, nil
+	return b.blob.Read(p)
 }
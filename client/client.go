@@ -0,0 +1,289 @@
+// Package client implements a client for the microblob HTTP API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miku/microblob/api"
+	"github.com/segmentio/encoding/json"
+)
+
+// Error is returned for unsuccessful responses. Use errors.Is with
+// api.ErrNotFound or api.ErrClosed to check for these cases.
+type Error struct {
+	StatusCode int
+	Message    string
+	Key        string
+}
+
+// Error returns the status and the message from the server.
+func (e *Error) Error() string {
+	if e.Key != "" {
+		return fmt.Sprintf("microblob: %d %s (key %s)", e.StatusCode, e.Message, e.Key)
+	}
+	return fmt.Sprintf("microblob: %d %s", e.StatusCode, e.Message)
+}
+
+// Unwrap maps status codes to the error classes of the microblob package.
+func (e *Error) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return api.ErrNotFound
+	case http.StatusServiceUnavailable:
+		return api.ErrClosed
+	default:
+		return nil
+	}
+}
+
+// Client talks to a microblob server. It is safe for concurrent use.
+type Client struct {
+	BaseURL     string        // server address, e.g. http://localhost:8820
+	HTTPClient  *http.Client  // connections are pooled by its transport
+	MaxRetries  int           // retries of idempotent requests on 5xx and 429 responses
+	Backoff     time.Duration // wait before first retry, doubled on each retry
+	Concurrency int           // parallel requests in BatchGet
+	Token       string        // bearer token, if the server requires authentication
+}
+
+// New returns a client with pooled connections and three retries.
+func New(baseURL string) *Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 32,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &Client{
+		BaseURL:     strings.TrimRight(baseURL, "/"),
+		HTTPClient:  &http.Client{Transport: transport},
+		MaxRetries:  3,
+		Backoff:     100 * time.Millisecond,
+		Concurrency: 8,
+	}
+}
+
+// Get returns the document stored under a key.
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := c.do(ctx, "GET", c.keyURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp, key)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// BatchGet fetches many documents in parallel. Missing keys are not part of
+// the result, other errors abort the batch.
+func (c *Client) BatchGet(ctx context.Context, keys []string) (map[string][]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		result   = make(map[string][]byte, len(keys))
+		firstErr error
+		queue    = make(chan string)
+		workers  = c.Concurrency
+	)
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range queue {
+				b, err := c.Get(ctx, key)
+				mu.Lock()
+				switch {
+				case err == nil:
+					result[key] = b
+				case isNotFound(err):
+				case firstErr == nil:
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, key := range keys {
+		select {
+		case queue <- key:
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Exists reports, which of the given keys are present.
+func (c *Client) Exists(ctx context.Context, keys []string) (map[string]bool, error) {
+	body, err := json.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, "POST", c.BaseURL+"/exists", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp, "")
+	}
+	result := make(map[string]bool)
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Count returns the number of keys.
+func (c *Client) Count(ctx context.Context) (int64, error) {
+	resp, err := c.do(ctx, "GET", c.BaseURL+"/count", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, readError(resp, "")
+	}
+	var result struct {
+		Count int64 `json:"count"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	return result.Count, nil
+}
+
+// Update streams newline delimited documents to the server, the key of each
+// document is taken from the given field. Updates are not retried, since the
+// reader cannot be rewound.
+func (c *Client) Update(ctx context.Context, keyField string, r io.Reader) error {
+	u := c.BaseURL + "/update?key=" + url.QueryEscape(keyField)
+	req, err := http.NewRequestWithContext(ctx, "POST", u, r)
+	if err != nil {
+		return err
+	}
+	c.authorize(req)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return readError(resp, "")
+	}
+	return nil
+}
+
+// keyURL returns the URL for a key. Keys, that would collide with other
+// routes or would be altered by path cleaning (like a//b), are passed as
+// escaped key parameter to the lookup route.
+func (c *Client) keyURL(key string) string {
+	if key == "" || api.Reserved(key) || path.Clean("/"+key) != "/"+key {
+		return c.BaseURL + api.RouteLookup + "?key=" + url.QueryEscape(key)
+	}
+	return c.BaseURL + "/" + (&url.URL{Path: key}).EscapedPath()
+}
+
+// do performs an idempotent request, retrying with exponential backoff on 5xx
+// and 429 responses and transport errors.
+func (c *Client) do(ctx context.Context, method, u string, body []byte) (*http.Response, error) {
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, u, r)
+		if err != nil {
+			return nil, err
+		}
+		c.authorize(req)
+		resp, err := c.HTTPClient.Do(req)
+		if attempt >= c.MaxRetries || ctx.Err() != nil {
+			return resp, err
+		}
+		wait := backoff
+		if err == nil {
+			if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+				return resp, nil
+			}
+			if s := resp.Header.Get("Retry-After"); s != "" {
+				if secs, err := strconv.Atoi(s); err == nil {
+					wait = time.Duration(secs) * time.Second
+				}
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// authorize adds the bearer token to a request, if there is one.
+func (c *Client) authorize(req *http.Request) {
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+}
+
+// readError turns an unsuccessful response into an Error, using the JSON
+// error body, if there is one.
+func readError(resp *http.Response, key string) error {
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	e := &Error{StatusCode: resp.StatusCode, Key: key}
+	var body struct {
+		Err string `json:"error"`
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(b, &body); err == nil && body.Err != "" {
+		e.Message = body.Err
+		if body.Key != "" {
+			e.Key = body.Key
+		}
+	} else {
+		e.Message = strings.TrimSpace(string(b))
+	}
+	return e
+}
+
+// isNotFound reports, whether an error is a not found response.
+func isNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,220 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miku/microblob"
+	"github.com/miku/microblob/api"
+)
+
+// testDocuments include keys, that need escaping or collide with routes;
+// unclean keys like a//b are sent through the lookup route.
+var testDocuments = map[string]string{
+	"a":              `{"id": "a"}`,
+	"b":              `{"id": "b"}`,
+	"a#b":            `{"id": "a#b"}`,
+	"with space":     `{"id": "with space"}`,
+	"100%":           `{"id": "100%"}`,
+	"x?y=z&w":        `{"id": "x?y=z&w"}`,
+	"a//b":           `{"id": "a//b"}`,
+	"a//b#c":         `{"id": "a//b#c"}`,
+	"./with space":   `{"id": "./with space"}`,
+	"a//100%":        `{"id": "a//100%"}`,
+	"a//b+c?d=e&f%2": `{"id": "a//b+c?d=e&f%2"}`,
+	"count":          `{"id": "count"}`,
+	"lookup":         `{"id": "lookup"}`,
+	"%41":            `{"id": "%41"}`,
+}
+
+// newTestServer serves testDocuments with NewHandler.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	dir := t.TempDir()
+	blobfile := filepath.Join(dir, "blob.ndjson")
+	var sb strings.Builder
+	for _, doc := range testDocuments {
+		sb.WriteString(doc + "\n")
+	}
+	if err := os.WriteFile(blobfile, []byte(sb.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	backend := &microblob.LevelDBBackend{Blobfile: blobfile, Filename: filepath.Join(dir, "blob.db")}
+	t.Cleanup(func() { backend.Close() })
+	extractor := microblob.ParsingExtractor{Key: "id"}
+	if err := microblob.AppendBatchSize(blobfile, "", backend, extractor.ExtractKey, 100, false); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(microblob.NewHandler(backend, blobfile))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestGet(t *testing.T) {
+	c := New(newTestServer(t).URL)
+	ctx := context.Background()
+	for key, doc := range testDocuments {
+		b, err := c.Get(ctx, key)
+		if err != nil {
+			t.Errorf("get %q: %v", key, err)
+			continue
+		}
+		if got := strings.TrimSpace(string(b)); got != doc {
+			t.Errorf("get %q: got %s, want %s", key, got, doc)
+		}
+	}
+	_, err := c.Get(ctx, "missing")
+	if !errors.Is(err, api.ErrNotFound) {
+		t.Fatalf("get missing: got %v, want not found", err)
+	}
+}
+
+func TestLegacyRoute(t *testing.T) {
+	ts := newTestServer(t)
+	// The raw query is the key, escape sequences are not decoded.
+	resp, err := http.Get(ts.URL + "/blob?%41")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := testDocuments["%41"]; resp.StatusCode != http.StatusOK || strings.TrimSpace(string(b)) != want {
+		t.Fatalf("got %d %s, want %s", resp.StatusCode, b, want)
+	}
+}
+
+func TestBatchGet(t *testing.T) {
+	c := New(newTestServer(t).URL)
+	result, err := c.BatchGet(context.Background(), []string{"a", "a//b#c", "missing", "count"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, key := range []string{"a", "a//b#c", "missing", "count"} {
+		if _, ok := result[key]; ok {
+			keys = append(keys, key)
+		}
+	}
+	if want := []string{"a", "a//b#c", "count"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("got %v, want %v", keys, want)
+	}
+}
+
+func TestExistsAndCount(t *testing.T) {
+	c := New(newTestServer(t).URL)
+	ctx := context.Background()
+	result, err := c.Exists(ctx, []string{"a", "./with space", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"a": true, "./with space": true, "missing": false}; !reflect.DeepEqual(result, want) {
+		t.Fatalf("exists: got %v, want %v", result, want)
+	}
+	n, err := c.Count(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(testDocuments)) {
+		t.Fatalf("count: got %d, want %d", n, len(testDocuments))
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	c := New(newTestServer(t).URL)
+	ctx := context.Background()
+	docs := "{\"id\": \"a\", \"v\": 2}\n{\"id\": \"new//100%\"}\n"
+	if err := c.Update(ctx, "id", strings.NewReader(docs)); err != nil {
+		t.Fatal(err)
+	}
+	b, err := c.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(b)), `{"id": "a", "v": 2}`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	if _, err := c.Get(ctx, "new//100%"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Update(ctx, "id", strings.NewReader("{\"no\": \"key\"}\n")); err == nil {
+		t.Fatal("update without key: expected error")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var cases = []struct {
+		about  string
+		status int
+	}{
+		{"server error", http.StatusServiceUnavailable},
+		{"rate limited", http.StatusTooManyRequests},
+	}
+	for _, tc := range cases {
+		ts := newTestServer(t)
+		var attempts int32
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&attempts, 1) <= 2 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(tc.status)
+				return
+			}
+			ts.Config.Handler.ServeHTTP(w, r)
+		}))
+		c := New(proxy.URL)
+		c.Backoff = time.Millisecond
+		if _, err := c.Get(context.Background(), "a"); err != nil {
+			t.Errorf("%s: %v", tc.about, err)
+		}
+		if n := atomic.LoadInt32(&attempts); n != 3 {
+			t.Errorf("%s: got %d attempts, want 3", tc.about, n)
+		}
+		atomic.StoreInt32(&attempts, -100)
+		c.MaxRetries = 1
+		_, err := c.Get(context.Background(), "a")
+		var e *Error
+		if !errors.As(err, &e) || e.StatusCode != tc.status {
+			t.Errorf("%s: got %v, want status %d after retries", tc.about, err, tc.status)
+		}
+		proxy.Close()
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	// A server, that does not respond in time.
+	blocking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer blocking.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := New(blocking.URL).Get(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want deadline exceeded", err)
+	}
+
+	// A server, that keeps failing, while the client backs off.
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	c := New(failing.URL)
+	c.Backoff = time.Hour
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	if _, err := c.Get(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("backoff ignored cancellation, took %v", elapsed)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/miku/microblob"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/ini.v1"
+)
+
+// runExport implements the export subcommand, which writes all live documents
+// of an existing database, newline delimited:
+//
+//	microblob export [-db DIR | -key KEY | -r PATTERN | -t] [-prefix P] [-fields F] [-z] [-o FILE] BLOBFILE
+//	microblob export -c microblob.ini [-prefix P] [-fields F] [-z] [-o FILE]
+func runExport(args []string) error {
+	var (
+		fs                = flag.NewFlagSet("export", flag.ExitOnError)
+		configFile        = fs.String("c", "", "load file and db options from a config (ini) file")
+		configFileSection = fs.String("s", "main", "the config file section to use")
+		dbFile            = fs.String("db", "", "database directory, derived from -key, -r or -t, if empty")
+		keypath           = fs.String("key", "", "key the database was built with")
+		pattern           = fs.String("r", "", "pattern the database was built with")
+		toplevel          = fs.Bool("t", false, "database was built with top level key extractor")
+		prefix            = fs.String("prefix", "", "only export documents with keys with this prefix")
+		fields            = fs.String("fields", "", "only export these comma separated fields, e.g. title,authors.name")
+		compress          = fs.Bool("z", false, "gzip output")
+		output            = fs.String("o", "", "output file, stdout if empty")
+		blobfile          string
+	)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: microblob export [OPTIONS] BLOBFILE\n\n")
+		fmt.Fprintf(fs.Output(), "Writes all live documents, newline delimited.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *configFile != "" {
+		cfg, err := ini.Load(*configFile)
+		if err != nil {
+			return fmt.Errorf("could not load config file %s: %v", *configFile, err)
+		}
+		section := cfg.Section(*configFileSection)
+		blobfile = section.Key("file").String()
+		*dbFile = section.Key("db").MustString(*dbFile)
+		*keypath = section.Key("key").MustString(*keypath)
+		*pattern = section.Key("pattern").MustString(*pattern)
+		*toplevel = section.Key("toplevel").MustBool(*toplevel)
+	}
+	if fs.NArg() > 0 {
+		blobfile = fs.Arg(0)
+	}
+	if blobfile == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *dbFile == "" {
+		if *keypath == "" && *pattern == "" && !*toplevel {
+			return fmt.Errorf("need -db, or -key, -r or -t to find database")
+		}
+		*dbFile = defaultDatabase(blobfile, "leveldb", *keypath, *pattern)
+	}
+	if _, err := os.Stat(*dbFile); err != nil {
+		return fmt.Errorf("database: %v", err)
+	}
+	backend := &microblob.LevelDBBackend{
+		Filename: *dbFile,
+		Blobfile: blobfile,
+		ReadOnly: true,
+	}
+	defer backend.Close()
+	var f = os.Stdout
+	if *output != "" {
+		var err error
+		if f, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	var (
+		bw           = bufio.NewWriter(f)
+		w  io.Writer = bw
+		zw *gzip.Writer
+	)
+	if *compress {
+		zw = gzip.NewWriter(bw)
+		w = zw
+	}
+	n, err := microblob.Export(w, backend, *prefix, microblob.ParseFields(*fields))
+	if err != nil {
+		return err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	log.Printf("exported %d documents from %s", n, *dbFile)
+	if *output != "" {
+		return f.Close()
+	}
+	return nil
+}
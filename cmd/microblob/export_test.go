@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miku/microblob"
+)
+
+func TestExportConfigToplevel(t *testing.T) {
+	dir := t.TempDir()
+	blobfile := filepath.Join(dir, "blob.ndjson")
+	data := "{\"a\": {\"v\": 1}}\n{\"b\": {\"v\": 2}}\n"
+	if err := os.WriteFile(blobfile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	backend := &microblob.LevelDBBackend{Blobfile: blobfile, Filename: defaultDatabase(blobfile, "leveldb", "", "")}
+	extractor := microblob.ToplevelKeyExtractor{}
+	if err := microblob.AppendBatchSize(blobfile, "", backend, extractor.ExtractKey, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	backend.Close()
+	config := filepath.Join(dir, "microblob.ini")
+	if err := os.WriteFile(config, []byte("[main]\nfile = "+blobfile+"\ntoplevel = true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "export.ndjson")
+	if err := runExport([]string{"-c", config, "-o", output}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != data {
+		t.Errorf("got %q, want %q", b, data)
+	}
+}
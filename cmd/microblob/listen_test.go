@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "microblob.sock")
+	ln, err := listen("unix:"+path, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("got mode %v, want 0600", fi.Mode().Perm())
+	}
+	if got := ln.Addr().String(); got != path {
+		t.Errorf("got addr %s, want %s", got, path)
+	}
+	if _, err := listen("unix:"+path, 0600); err == nil {
+		t.Fatal("replaced a socket in use")
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("socket in use was removed: %v", err)
+	}
+	conn.Close()
+	ln.Close()
+
+	// A stale socket file is replaced.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	if ln, err = listen("unix:"+path, 0660); err != nil {
+		t.Fatalf("stale socket: %v", err)
+	}
+	defer ln.Close()
+	if fi, err = os.Stat(path); err != nil || fi.Mode().Perm() != 0660 {
+		t.Errorf("got %v, %v, want mode 0660", fi, err)
+	}
+}
+
+func TestListenNotASocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listen("unix:"+path, 0600); err == nil {
+		t.Fatal("replaced a regular file")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// logFile is a log file, that can be reopened after it has been moved away,
+// e.g. by logrotate, which then sends SIGHUP.
+type logFile struct {
+	name string
+	mu   sync.Mutex
+	f    *os.File
+}
+
+// openLogFile opens a file for appending.
+func openLogFile(name string) (*logFile, error) {
+	lf := &logFile{name: name}
+	if err := lf.Reopen(); err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
+
+// Write writes to the current file.
+func (lf *logFile) Write(p []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	return lf.f.Write(p)
+}
+
+// Reopen opens the file by name again and closes the previous one. If the file
+// cannot be opened, logging continues to the previous file.
+func (lf *logFile) Reopen() error {
+	f, err := os.OpenFile(lf.name, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	if lf.f != nil {
+		lf.f.Close()
+	}
+	lf.f = f
+	return nil
+}
+
+// Close closes the current file.
+func (lf *logFile) Close() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	return lf.f.Close()
+}
+
+// newFormatter returns a logrus formatter for a log format: text or json.
+func newFormatter(format string) (log.Formatter, error) {
+	switch format {
+	case "text":
+		return &log.TextFormatter{}, nil
+	case "json":
+		return &log.JSONFormatter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown log format: %s, want text or json", format)
+	}
+}
+
+// setupLogging configures the application log.
+func setupLogging(format, level string) error {
+	formatter, err := newFormatter(format)
+	if err != nil {
+		return err
+	}
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	log.SetFormatter(formatter)
+	log.SetLevel(lvl)
+	return nil
+}
@@ -4,23 +4,29 @@
 // port = 8820
 // host = 0.0.0.0
 // batchsize = 30000
-//
 package main
 
 import (
+	"context"
 	"crypto/sha1"
+	"crypto/tls"
 	_ "expvar"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
 	"regexp"
+	"strings"
+	"syscall"
 
 	"github.com/gorilla/handlers"
 	"github.com/miku/microblob"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"gopkg.in/ini.v1"
 )
 
@@ -31,22 +37,52 @@ var (
 	toplevel          = flag.Bool("t", false, "top level key extractor")
 	keypath           = flag.String("key", "", "key to extract, json, top-level only")
 	dbname            = flag.String("backend", "leveldb", "backend to use: leveldb, debug")
-	addr              = flag.String("addr", "127.0.0.1:8820", "address to serve")
+	addr              = flag.String("addr", "127.0.0.1:8820", "address to serve, or unix:/path/to/socket")
+	socketMode        = flag.String("socket-mode", "0660", "permissions of unix domain sockets")
+	rateLimit         = flag.Float64("rate-limit", 0, "requests per second per client (IP or credential), no limit if zero")
+	rateBurst         = flag.Int("rate-burst", 0, "requests a client may do at once, defaults to rate limit")
+	maxInFlight       = flag.Int("max-in-flight", 0, "maximum number of concurrent document requests, no limit if zero")
+	traceFile         = flag.String("trace-file", "", "write trace spans as JSON to this file")
+	otlpEndpoint      = flag.String("otlp-endpoint", "", "export trace spans via OTLP/HTTP, e.g. http://localhost:4318")
+	traceSample       = flag.Float64("trace-sample", 1, "fraction of requests to trace, unless the caller decided")
+	tlsCert           = flag.String("tls-cert", "", "TLS certificate file, serve HTTPS if set, reloaded on SIGHUP")
+	tlsKey            = flag.String("tls-key", "", "TLS key file")
+	tlsClientCA       = flag.String("tls-client-ca", "", "CA certificates to verify clients with, write routes require a client certificate if set")
 	batchsize         = flag.Int("batch", 50000, "number of lines in a batch")
 	version           = flag.Bool("version", false, "show version and exit")
-	logfile           = flag.String("log", "", "access log file, don't log if empty")
+	logfile           = flag.String("log", "", "access log file, don't log if empty, reopened on SIGHUP")
+	appLogfile        = flag.String("app-log", "", "application log file, stderr if empty, reopened on SIGHUP")
+	logFormat         = flag.String("log-format", "text", "format of access and application logs: text or json")
+	logLevel          = flag.String("log-level", "info", "application log level: debug, info, warn, error")
 	ignoreMissingKeys = flag.Bool("ignore-missing-keys", false, "ignore record, that do not have a the specified key")
 	dbOnly            = flag.Bool("create-db-only", false, "build the database only, then exit")
+	readOnly          = flag.Bool("read-only", false, "serve an existing database read-only, without update routes")
 	dbFile            = flag.String("db", "", "the root directory, by default: 1000.ldj -> 1000.ldj.05028f38.db (based on flags)")
+	cacheControl      = flag.String("cache-control", "", "Cache-Control header to send with documents, e.g. 'public, max-age=3600'")
+	hotKeys           = flag.Int("hot-keys", 0, "number of hot keys to report at /debug/hotkeys, disabled if zero")
+	hotKeysSample     = flag.Float64("hot-keys-sample", 0.1, "fraction of requests sampled for hot keys")
+	grpcAddr          = flag.String("grpc-addr", "", "address to serve gRPC, e.g. 127.0.0.1:8821, disabled if empty")
+	memcacheAddr      = flag.String("memcache-addr", "", "address to serve memcached protocol (read only), e.g. 127.0.0.1:11211, disabled if empty")
+	readyKeys         = flag.String("ready-keys", "", "comma separated keys, that must be readable for the instance to be ready")
+	redisAddr         = flag.String("redis-addr", "", "address to serve redis protocol (read only), e.g. 127.0.0.1:6379, disabled if empty")
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExport(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
 	flag.Parse()
 	if *version {
 		fmt.Println(microblob.Version)
 		os.Exit(0)
 	}
-	var blobfile string
+	var (
+		blobfile string
+		auth     *microblob.Auth
+	)
 	if *configFile != "" {
 		log.Printf(*configFile)
 		// Load config file and set flag values.
@@ -62,7 +98,34 @@ func main() {
 		*dbFile = section.Key("db").String()
 		*addr = section.Key("addr").String()
 		*logfile = section.Key("log").String()
+		*appLogfile = section.Key("app-log").MustString(*appLogfile)
+		*logFormat = section.Key("log-format").MustString(*logFormat)
+		*logLevel = section.Key("log-level").MustString(*logLevel)
 		*batchsize, err = section.Key("batch").Int()
+		*cacheControl = section.Key("cache-control").MustString(*cacheControl)
+		*readOnly = section.Key("read-only").MustBool(*readOnly)
+		*grpcAddr = section.Key("grpc-addr").MustString(*grpcAddr)
+		*memcacheAddr = section.Key("memcache-addr").MustString(*memcacheAddr)
+		*redisAddr = section.Key("redis-addr").MustString(*redisAddr)
+		*readyKeys = section.Key("ready-keys").MustString(*readyKeys)
+		*hotKeys = section.Key("hot-keys").MustInt(*hotKeys)
+		*hotKeysSample = section.Key("hot-keys-sample").MustFloat64(*hotKeysSample)
+		*socketMode = section.Key("socket-mode").MustString(*socketMode)
+		*rateLimit = section.Key("rate-limit").MustFloat64(*rateLimit)
+		*rateBurst = section.Key("rate-burst").MustInt(*rateBurst)
+		*maxInFlight = section.Key("max-in-flight").MustInt(*maxInFlight)
+		*traceFile = section.Key("trace-file").MustString(*traceFile)
+		*otlpEndpoint = section.Key("otlp-endpoint").MustString(*otlpEndpoint)
+		*traceSample = section.Key("trace-sample").MustFloat64(*traceSample)
+		*tlsCert = section.Key("tls-cert").MustString(*tlsCert)
+		*tlsKey = section.Key("tls-key").MustString(*tlsKey)
+		*tlsClientCA = section.Key("tls-client-ca").MustString(*tlsClientCA)
+		if auth, err = loadAuth(section); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if auth != nil && auth.RequireRead && (*memcacheAddr != "" || *redisAddr != "") {
+		log.Fatal("memcache-addr and redis-addr do not support authentication and cannot be used with require-read-token")
 	}
 	if *configFile == "" && flag.NArg() == 0 {
 		log.Fatal("file to index (and serve) required")
@@ -77,21 +140,41 @@ func main() {
 		log.Fatal("need path, pattern or -t to identify key")
 	}
 	if *dbFile == "" {
-		h := sha1.New()
-		if _, err := fmt.Fprintf(h, "%s:%s:%s", *dbname, *keypath, *pattern); err != nil {
+		*dbFile = defaultDatabase(blobfile, *dbname, *keypath, *pattern)
+	}
+
+	if err := setupLogging(*logFormat, *logLevel); err != nil {
+		log.Fatal(err)
+	}
+	// Log files are reopened on SIGHUP, along with the TLS certificate.
+	var hangup []func()
+	if *appLogfile != "" {
+		lf, err := openLogFile(*appLogfile)
+		if err != nil {
 			log.Fatal(err)
 		}
-		*dbFile = fmt.Sprintf("%s.%.4x.db", blobfile, h.Sum(nil))
+		defer lf.Close()
+		log.SetOutput(lf)
+		hangup = append(hangup, func() {
+			if err := lf.Reopen(); err != nil {
+				log.Printf("keeping previous log file: %v", err)
+			}
+		})
+	}
+	shutdownTracing, err := setupTracing(*traceFile, *otlpEndpoint, *traceSample)
+	if err != nil {
+		log.Fatal(err)
 	}
-
 	var backend microblob.Backend
 	switch *dbname {
 	case "debug":
 		backend = microblob.DebugBackend{Writer: os.Stdout}
 	default:
 		backend = &microblob.LevelDBBackend{
-			Filename: *dbFile,
-			Blobfile: blobfile,
+			Filename:  *dbFile,
+			Blobfile:  blobfile,
+			ReadOnly:  *readOnly,
+			Extractor: extractorName(*pattern, *keypath, *toplevel),
 		}
 	}
 	defer func() {
@@ -100,17 +183,25 @@ func main() {
 		}
 	}()
 
-	var loggingWriter = ioutil.Discard
+	var loggingWriter io.Writer = ioutil.Discard
 	if *logfile != "" {
-		file, err := os.OpenFile(*logfile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+		lf, err := openLogFile(*logfile)
 		if err != nil {
 			log.Fatal(err)
 		}
-		loggingWriter = file
-		defer file.Close()
+		loggingWriter = lf
+		defer lf.Close()
+		hangup = append(hangup, func() {
+			if err := lf.Reopen(); err != nil {
+				log.Printf("keeping previous access log file: %v", err)
+			}
+		})
 	}
 	// If dbfile does not exists, create it now.
 	if _, err := os.Stat(*dbFile); os.IsNotExist(err) {
+		if *readOnly {
+			log.Fatalf("read-only mode requires an existing database: %s", *dbFile)
+		}
 		log.Printf("creating db %s ...", *dbFile)
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt)
@@ -147,14 +238,203 @@ func main() {
 		signal.Stop(c)
 	}
 	if *dbOnly {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Printf("tracing: %v", err)
+		}
 		os.Exit(0)
 	}
-	log.Printf("listening at http://%v (%s)", *addr, *dbFile)
+	if *traceFile != "" || *otlpEndpoint != "" {
+		// Flush pending spans on shutdown.
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-sigs
+			if err := shutdownTracing(context.Background()); err != nil {
+				log.Printf("tracing: %v", err)
+			}
+			log.Printf("%v -- exiting", sig)
+			os.Exit(0)
+		}()
+	}
+	mode, err := parseFileMode(*socketMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var (
+		tlsReloader *microblob.TLSReloader
+		grpcOpts    = auth.GRPCServerOptions()
+		scheme      = "http"
+	)
+	if *tlsCert != "" || *tlsKey != "" {
+		if tlsReloader, err = microblob.NewTLSReloader(*tlsCert, *tlsKey, *tlsClientCA); err != nil {
+			log.Fatal(err)
+		}
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(tlsReloader.Config())))
+		if *tlsClientCA != "" {
+			grpcOpts = append(grpcOpts, microblob.RequireClientCertGRPC())
+		}
+		scheme = "https"
+		hangup = append(hangup, func() {
+			if err := tlsReloader.Reload(); err != nil {
+				log.Printf("keeping previous certificate: %v", err)
+				return
+			}
+			log.Printf("reloaded certificate from %s", *tlsCert)
+		})
+	} else if *tlsClientCA != "" {
+		log.Fatal("-tls-client-ca requires -tls-cert and -tls-key")
+	}
+	if len(hangup) > 0 {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				for _, f := range hangup {
+					f()
+				}
+			}
+		}()
+	}
+	if *grpcAddr != "" {
+		ln, err := listen(*grpcAddr, mode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("listening at grpc://%v (%s)", *grpcAddr, *dbFile)
+		go func() {
+			server := microblob.NewGRPCServer(backend, blobfile, *readOnly, grpcOpts...)
+			if err := server.Serve(ln); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if *memcacheAddr != "" {
+		ln, err := listen(*memcacheAddr, mode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("listening at memcache://%v (%s)", *memcacheAddr, *dbFile)
+		go func() {
+			server := &microblob.MemcacheServer{Backend: backend}
+			if err := server.Serve(ln); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+	if *redisAddr != "" {
+		ln, err := listen(*redisAddr, mode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("listening at redis://%v (%s)", *redisAddr, *dbFile)
+		go func() {
+			server := &microblob.RedisServer{Backend: backend}
+			if err := server.Serve(ln); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+	// With systemd socket activation, the socket is passed in and addr ignored.
+	ln, err := activationListener()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ln == nil {
+		if ln, err = listen(*addr, mode); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if tlsReloader != nil {
+		ln = tls.NewListener(ln, tlsReloader.Config())
+	}
+	log.Printf("listening at %s://%v (%s)", scheme, ln.Addr(), *dbFile)
 	var (
-		r            = microblob.NewHandler(backend, blobfile)
+		opts = microblob.HandlerOptions{
+			CacheControl:      *cacheControl,
+			Auth:              auth,
+			RequireClientCert: *tlsClientCA != "",
+			ReadOnly:          *readOnly,
+			RateLimit:         *rateLimit,
+			RateBurst:         *rateBurst,
+			MaxInFlight:       *maxInFlight,
+			ReadyKeys:         splitList(*readyKeys),
+			HotKeys:           *hotKeys,
+			HotKeysSample:     *hotKeysSample,
+		}
+		r            = microblob.NewHandlerOptions(backend, blobfile, opts)
 		loggedRouter = handlers.LoggingHandler(loggingWriter, r)
 	)
-	if err := http.ListenAndServe(*addr, loggedRouter); err != nil {
+	if *logFormat == "json" {
+		accessLogger := log.New()
+		accessLogger.SetFormatter(&log.JSONFormatter{})
+		accessLogger.SetOutput(loggingWriter)
+		loggedRouter = microblob.WithAccessLog(accessLogger, r)
+	}
+	if err := http.Serve(ln, loggedRouter); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// loadAuth reads credentials from a config section:
+//
+//	write-tokens = indexer:s3cr3t
+//	read-tokens = frontend:t0k3n, reports:x
+//	require-read-token = true
+//
+// Returns nil, if no credentials are configured.
+func loadAuth(section *ini.Section) (*microblob.Auth, error) {
+	auth := &microblob.Auth{
+		RequireRead: section.Key("require-read-token").MustBool(false),
+		MaxSkew:     section.Key("signature-max-age").MustDuration(0),
+	}
+	for key, scope := range map[string]microblob.Scope{
+		"read-tokens":  microblob.ScopeRead,
+		"write-tokens": microblob.ScopeWrite,
+	} {
+		creds, err := microblob.ParseCredentials(section.Key(key).String(), scope)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		auth.Credentials = append(auth.Credentials, creds...)
+	}
+	if len(auth.Credentials) == 0 {
+		if auth.RequireRead {
+			return nil, fmt.Errorf("require-read-token set, but no tokens configured")
+		}
+		return nil, nil
+	}
+	return auth, nil
+}
+
+// extractorName describes the key extractor selected by flags, with the same
+// precedence as used when building the database.
+func extractorName(pattern, keypath string, toplevel bool) string {
+	switch {
+	case pattern != "":
+		return "regexp:" + pattern
+	case keypath != "":
+		return "key:" + keypath
+	case toplevel:
+		return "toplevel"
+	default:
+		return ""
+	}
+}
+
+// splitList splits a comma separated list, dropping empty elements.
+func splitList(s string) (result []string) {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// defaultDatabase derives the database directory from the blob file name and
+// the flags, that influence the keys.
+func defaultDatabase(blobfile, backend, keypath, pattern string) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s:%s:%s", backend, keypath, pattern)
+	return fmt.Sprintf("%s.%.4x.db", blobfile, h.Sum(nil))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miku/microblob"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace/noop"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector stands in for an OTLP/HTTP collector and keeps the spans it
+// receives.
+type collector struct {
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req collectortrace.ExportTraceServiceRequest
+	if err := proto.Unmarshal(b, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	b, _ = proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	w.Write(b)
+}
+
+// find returns the first span with a given name.
+func (c *collector) find(name string) *tracepb.Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestTracingOTLP(t *testing.T) {
+	c := &collector{}
+	ts := httptest.NewServer(c)
+	defer ts.Close()
+	shutdown, err := setupTracing("", ts.URL+"/v1/traces", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	dir := t.TempDir()
+	blobfile := filepath.Join(dir, "blob.ndjson")
+	if err := os.WriteFile(blobfile, []byte("{\"id\": \"a\"}\n{\"id\": \"b\"}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	backend := &microblob.LevelDBBackend{Blobfile: blobfile, Filename: filepath.Join(dir, "blob.db")}
+	defer backend.Close()
+	extractor := microblob.ParsingExtractor{Key: "id"}
+	if err := microblob.AppendBatchSize(blobfile, "", backend, extractor.ExtractKey, 1, false); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(microblob.NewHandler(backend, blobfile))
+	defer server.Close()
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	req, err := http.NewRequest("GET", server.URL+"/a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("traceparent", "00-"+traceID+"-"+spanID+"-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+	resp, err = http.Post(server.URL+"/update?key=id", "application/x-ndjson", strings.NewReader("{\"id\": \"c\"}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("update: got status %d, want 200", resp.StatusCode)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("flush spans: %v", err)
+	}
+
+	for _, name := range []string{"AppendBatchSize", "AppendBatchSize.copy", "LineProcessor.RunWithWorkers", "UpdateHandler.ServeHTTP"} {
+		if c.find(name) == nil {
+			t.Errorf("span %s not exported", name)
+		}
+	}
+	root := c.find("BlobHandler.ServeHTTP")
+	if root == nil {
+		t.Fatal("span BlobHandler.ServeHTTP not exported")
+	}
+	if got := hex.EncodeToString(root.TraceId); got != traceID {
+		t.Errorf("traceparent ignored: got trace id %s, want %s", got, traceID)
+	}
+	if got := hex.EncodeToString(root.ParentSpanId); got != spanID {
+		t.Errorf("traceparent ignored: got parent span id %s, want %s", got, spanID)
+	}
+	for _, name := range []string{"Backend.Lookup", "Backend.ReadEntry"} {
+		s := c.find(name)
+		if s == nil {
+			t.Errorf("span %s not exported", name)
+			continue
+		}
+		if string(s.TraceId) != string(root.TraceId) || string(s.ParentSpanId) != string(root.SpanId) {
+			t.Errorf("span %s is not a child of BlobHandler.ServeHTTP", name)
+		}
+	}
+}
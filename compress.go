@@ -0,0 +1,206 @@
+package microblob
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// minCompressLength is the smallest body (as announced by Content-Length)
+// worth compressing.
+const minCompressLength = 256
+
+// encodings lists the supported content codings in order of preference.
+var encodings = []string{"zstd", "br", "gzip"}
+
+// encoderPools keep encoders around for reuse, some are expensive to set up.
+var encoderPools = map[string]*sync.Pool{
+	"gzip": {New: func() interface{} { return gzip.NewWriter(nil) }},
+	"br": {New: func() interface{} {
+		return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+	}},
+	"zstd": {New: func() interface{} {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return enc
+	}},
+}
+
+// resetWriteCloser is implemented by all pooled encoders.
+type resetWriteCloser interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// WithCompression compresses responses with gzip, zstd or brotli, depending
+// on the Accept-Encoding header of the request. Responses, that already carry
+// a Content-Encoding, are passed through unchanged. Entity tags are suffixed
+// with the coding, since the compressed representation differs from the
+// stored one.
+func WithCompression(h http.Handler) http.Handler {
+	f := func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			w.Header().Add("Vary", "Accept-Encoding")
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, head: r.Method == "HEAD"}
+		defer cw.Close()
+		h.ServeHTTP(cw, r)
+	}
+	return http.HandlerFunc(f)
+}
+
+// negotiateEncoding picks a content coding from an Accept-Encoding header
+// value, honoring quality values. Returns the empty string for identity.
+func negotiateEncoding(header string) string {
+	if header == "" {
+		return ""
+	}
+	var (
+		q        = make(map[string]float64)
+		wildcard = -1.0
+	)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		weight := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					weight = v
+				}
+			}
+		}
+		if name == "*" {
+			wildcard = weight
+			continue
+		}
+		q[name] = weight
+	}
+	var (
+		best       string
+		bestWeight float64
+	)
+	for _, enc := range encodings {
+		weight, ok := q[enc]
+		if !ok {
+			weight = wildcard
+		}
+		if weight > bestWeight {
+			best, bestWeight = enc, weight
+		}
+	}
+	return best
+}
+
+// encodingETag marks an entity tag as belonging to an encoded representation.
+func encodingETag(etag, encoding string) string {
+	if !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + encoding + `"`
+}
+
+// stripEncodingETag returns the entity tag of the stored representation, for
+// a tag, that might have been marked by encodingETag.
+func stripEncodingETag(etag string) string {
+	for _, enc := range encodings {
+		suffix := "-" + enc + `"`
+		if strings.HasSuffix(etag, suffix) {
+			return etag[:len(etag)-len(suffix)] + `"`
+		}
+	}
+	return etag
+}
+
+// compressWriter decides on the first write or header, whether the response
+// gets compressed.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	enc         resetWriteCloser
+	wroteHeader bool
+	head        bool // headers as for GET, but no body
+}
+
+// WriteHeader sets up the encoder, if the response is worth compressing.
+func (w *compressWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	h.Add("Vary", "Accept-Encoding")
+	if h.Get("Content-Encoding") != "" {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if code == http.StatusNotModified {
+		// Validators must match the ones of the full response.
+		if compressible(h) {
+			if etag := h.Get("ETag"); etag != "" {
+				h.Set("ETag", encodingETag(etag, w.encoding))
+			}
+			h.Del("Content-Length")
+		}
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if code != http.StatusOK || !compressible(h) {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if etag := h.Get("ETag"); etag != "" {
+		h.Set("ETag", encodingETag(etag, w.encoding))
+	}
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", w.encoding)
+	if w.head {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.enc = encoderPools[w.encoding].Get().(resetWriteCloser)
+	w.enc.Reset(w.ResponseWriter)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// compressible returns false for responses, that announce a body shorter than
+// minCompressLength.
+func compressible(h http.Header) bool {
+	if cl := h.Get("Content-Length"); cl != "" {
+		if n, err := strconv.Atoi(cl); err == nil && n < minCompressLength {
+			return false
+		}
+	}
+	return true
+}
+
+// Write writes compressed data, if compression has been chosen.
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc == nil {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.enc.Write(p)
+}
+
+// Close flushes the encoder and returns it to its pool.
+func (w *compressWriter) Close() error {
+	if w.enc == nil {
+		return nil
+	}
+	err := w.enc.Close()
+	encoderPools[w.encoding].Put(w.enc)
+	w.enc = nil
+	return err
+}
@@ -0,0 +1,79 @@
+package microblob
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompressionETagOnNotModified(t *testing.T) {
+	dir := t.TempDir()
+	blobfile := filepath.Join(dir, "blob.ndjson")
+	large := `{"id": "large", "text": "` + strings.Repeat("x", 2*minCompressLength) + `"}`
+	if err := os.WriteFile(blobfile, []byte("{\"id\": \"small\"}\n"+large+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	backend := &LevelDBBackend{Blobfile: blobfile, Filename: filepath.Join(dir, "blob.db")}
+	defer backend.Close()
+	extractor := ParsingExtractor{Key: "id"}
+	if err := AppendBatchSize(blobfile, "", backend, extractor.ExtractKey, 2, false); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(NewHandler(backend, blobfile))
+	defer ts.Close()
+	do := func(method, key, ifNoneMatch string) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+"/"+key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Encoding", "gzip")
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	get := func(key, ifNoneMatch string) *http.Response {
+		return do("GET", key, ifNoneMatch)
+	}
+	var cases = []struct {
+		key     string
+		encoded bool
+	}{
+		{"small", false},
+		{"large", true},
+	}
+	for _, c := range cases {
+		resp := get(c.key, "")
+		etag := resp.Header.Get("ETag")
+		if resp.StatusCode != http.StatusOK || etag == "" {
+			t.Fatalf("%s: got status %d, etag %q", c.key, resp.StatusCode, etag)
+		}
+		if got := strings.HasSuffix(etag, `-gzip"`); got != c.encoded {
+			t.Errorf("%s: etag %s, want encoded %v", c.key, etag, c.encoded)
+		}
+		head := do("HEAD", c.key, "")
+		for _, name := range []string{"ETag", "Content-Encoding"} {
+			if got, want := head.Header.Get(name), resp.Header.Get(name); got != want {
+				t.Errorf("%s: HEAD sends %s %q, GET sent %q", c.key, name, got, want)
+			}
+		}
+		if cl := head.Header.Get("Content-Length"); c.encoded && cl != "" {
+			t.Errorf("%s: HEAD sends identity Content-Length %s for an encoded response", c.key, cl)
+		}
+		resp = get(c.key, etag)
+		if resp.StatusCode != http.StatusNotModified {
+			t.Fatalf("%s: got status %d, want 304", c.key, resp.StatusCode)
+		}
+		if got := resp.Header.Get("ETag"); got != etag {
+			t.Errorf("%s: 304 sends etag %s, 200 sent %s", c.key, got, etag)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package microblob
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/miku/microblob/api"
+	"github.com/syndtr/goleveldb/leveldb"
+	lerrors "github.com/syndtr/goleveldb/leveldb/errors"
+)
+
+// Error classes, test backend errors with errors.Is. They are defined in the
+// api package, which clients can import without the server.
+var (
+	// ErrNotFound if a key does not exist.
+	ErrNotFound = api.ErrNotFound
+	// ErrCorrupt if an index entry or a document is damaged.
+	ErrCorrupt = api.ErrCorrupt
+	// ErrIO if the index or the blob file cannot be read.
+	ErrIO = api.ErrIO
+	// ErrClosed if the backend has been closed.
+	ErrClosed = api.ErrClosed
+)
+
+// ErrReadOnly if a write is attempted on a backend opened read-only.
+var ErrReadOnly = api.ErrReadOnly
+
+// ErrInvalidValue if a value is corrupted, it is of class ErrCorrupt.
+var ErrInvalidValue error = &BackendError{Class: ErrCorrupt, Err: errors.New("invalid entry")}
+
+// BackendError attaches an error class to an error.
+type BackendError struct {
+	Class error // one of ErrNotFound, ErrCorrupt, ErrIO, ErrClosed
+	Err   error
+}
+
+// Error returns the class and the message of the wrapped error.
+func (e *BackendError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Class, e.Err)
+}
+
+// Unwrap allows errors.Is to match both class and wrapped error.
+func (e *BackendError) Unwrap() []error {
+	return []error{e.Class, e.Err}
+}
+
+// classify wraps an error, so it belongs to one of the error classes. Errors
+// without an obvious class are considered I/O errors.
+func classify(err error) error {
+	var be *BackendError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &be), errors.Is(err, ErrNotFound), errors.Is(err, ErrClosed):
+		return err
+	case errors.Is(err, leveldb.ErrNotFound):
+		return ErrNotFound
+	case errors.Is(err, leveldb.ErrClosed), errors.Is(err, os.ErrClosed):
+		return ErrClosed
+	case lerrors.IsCorrupted(err):
+		return &BackendError{Class: ErrCorrupt, Err: err}
+	default:
+		return &BackendError{Class: ErrIO, Err: err}
+	}
+}
@@ -0,0 +1,72 @@
+package microblob
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Export writes all live documents, that is the documents currently indexed,
+// newline delimited and in key order to a writer. Documents, that have been
+// replaced by updates, are skipped, so every key is written exactly once. An
+// empty prefix exports everything. If fields are given, documents are reduced
+// to these paths, see Project. Returns the number of documents written.
+func Export(w io.Writer, backend Backend, prefix string, fields []string) (n int64, err error) {
+	scanner, ok := backend.(Scanner)
+	if !ok {
+		return 0, fmt.Errorf("backend does not support scans")
+	}
+	reader, ok := backend.(EntryReader)
+	if !ok {
+		return 0, fmt.Errorf("backend does not support entry reads")
+	}
+	_, err = scanner.Scan(ScanOptions{Prefix: prefix}, func(e Entry) error {
+		b, err := reader.ReadEntry(e)
+		if err == nil && len(fields) > 0 {
+			b, err = Project(b, fields)
+		}
+		if err != nil {
+			return fmt.Errorf("export %s: %w", e.Key, err)
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		if len(b) > 0 && b[len(b)-1] != '\n' {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// ExportHandler streams all live documents, optionally restricted to keys
+// with a given prefix and reduced to some fields.
+type ExportHandler struct {
+	Backend Backend
+}
+
+// ServeHTTP streams documents as newline delimited data. Errors after the
+// first document cannot be reported to the client anymore and are logged.
+func (h ExportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.Backend.(Scanner); !ok {
+		writeError(w, http.StatusNotImplemented, "", fmt.Errorf("backend does not support scans"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	var (
+		prefix = r.URL.Query().Get("prefix")
+		fields = ParseFields(r.URL.Query().Get("fields"))
+	)
+	n, err := Export(w, h.Backend, prefix, fields)
+	switch {
+	case err != nil && n == 0:
+		writeBackendError(w, r, "", err)
+	case err != nil:
+		log.WithField("request_id", RequestID(r)).Printf("export failed after %d documents: %v", n, err)
+	}
+}
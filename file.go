@@ -1,24 +1,107 @@
 package microblob
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // mu protects updates.
 var mu sync.Mutex
 
+// PreconditionError reports a key, whose current version did not match any of
+// the entity tags required by a conditional update.
+type PreconditionError struct {
+	Key  string
+	ETag string // current entity tag, empty if the key does not exist
+}
+
+// Error reports the key and its current version.
+func (e *PreconditionError) Error() string {
+	if e.ETag == "" {
+		return fmt.Sprintf("precondition failed: key %s does not exist", e.Key)
+	}
+	return fmt.Sprintf("precondition failed: key %s is at %s", e.Key, e.ETag)
+}
+
 // Append add a file to an existing blob file and adds their keys to the store.
 func Append(blobfn, fn string, backend Backend, kf KeyFunc) error {
 	return AppendBatchSize(blobfn, fn, backend, kf, 100000, false)
 }
 
+// lock acquires the update lock, waiting for other updates is traced.
+func lock(ctx context.Context) {
+	_, span := startSpan(ctx, "lock")
+	mu.Lock()
+	span.End()
+}
+
+// AppendIfMatch works like Append, but only appends, if the current version of
+// every key in the file matches one of the given entity tags, "*" matches any
+// existing key. The check and the append happen under the same lock, so
+// concurrent writers cannot overwrite each other unnoticed. Without entity
+// tags, the append is unconditional. The context only carries the trace, an
+// update is never canceled halfway.
+func AppendIfMatch(ctx context.Context, blobfn, fn string, backend Backend, kf KeyFunc, etags []string) error {
+	lock(ctx)
+	defer mu.Unlock()
+	if len(etags) > 0 {
+		if err := checkPreconditions(ctx, fn, backend, kf, etags); err != nil {
+			return err
+		}
+	}
+	return appendBatchSize(ctx, blobfn, fn, backend, kf, 100000, false)
+}
+
+// AppendDocument appends the single document in file fn and indexes it under
+// the given key, regardless of its content. Entity tags work as in
+// AppendIfMatch. Returns the new entry and whether the key has been created.
+func AppendDocument(ctx context.Context, blobfn, fn, key string, backend Backend, etags []string) (entry Entry, created bool, err error) {
+	lock(ctx)
+	defer mu.Unlock()
+	lookuper, ok := backend.(Lookuper)
+	if !ok {
+		return entry, false, fmt.Errorf("backend does not support entry lookups")
+	}
+	kf := func([]byte) (string, error) { return key, nil }
+	if len(etags) > 0 {
+		if err = checkPreconditions(ctx, fn, backend, kf, etags); err != nil {
+			return entry, false, err
+		}
+	}
+	if _, err = lookuper.Lookup(key); err == ErrNotFound {
+		created = true
+	} else if err != nil {
+		return entry, false, err
+	}
+	if err = appendBatchSize(ctx, blobfn, fn, backend, kf, 1, false); err != nil {
+		return entry, false, err
+	}
+	entry, err = lookuper.Lookup(key)
+	return entry, created, err
+}
+
 // AppendBatchSize uses a given batch size.
 func AppendBatchSize(blobfn, fn string, backend Backend, kf KeyFunc, size int, ignoreMissingKeys bool) (err error) {
 	mu.Lock()
 	defer mu.Unlock()
+	return appendBatchSize(context.Background(), blobfn, fn, backend, kf, size, ignoreMissingKeys)
+}
+
+// appendBatchSize appends and indexes a file, caller must hold mu.
+func appendBatchSize(ctx context.Context, blobfn, fn string, backend Backend, kf KeyFunc, size int, ignoreMissingKeys bool) (err error) {
+	defer func(started time.Time) { observeUpdate(fn, started, err) }(time.Now())
+	ctx, span := startSpan(ctx, "AppendBatchSize", attribute.String("microblob.blobfile", blobfn),
+		attribute.Int("microblob.batch_size", size))
+	defer func() { endSpan(span, err) }()
 	file, err := os.OpenFile(blobfn, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		return err
@@ -36,7 +119,11 @@ func AppendBatchSize(blobfn, fn string, backend Backend, kf KeyFunc, size int, i
 		}
 		defer f.Close()
 
-		if _, err := io.Copy(file, f); err != nil {
+		_, copySpan := startSpan(ctx, "AppendBatchSize.copy", attribute.Int64("microblob.offset", offset))
+		n, err := io.Copy(file, f)
+		copySpan.SetAttributes(attribute.Int64("microblob.bytes", n))
+		endSpan(copySpan, err)
+		if err != nil {
 			return err
 		}
 		if _, err := file.Seek(offset, io.SeekStart); err != nil {
@@ -48,12 +135,78 @@ func AppendBatchSize(blobfn, fn string, backend Backend, kf KeyFunc, size int, i
 	processor.InitialOffset = offset
 	processor.Verbose = true
 	processor.IgnoreMissingKeys = ignoreMissingKeys
+	processor.Context = ctx
 	if err = processor.RunWithWorkers(); err != nil {
 		if fn != "" {
-			if terr := os.Truncate(blobfn, offset); terr != nil {
-				return fmt.Errorf("processing and truncate failed: %v, %v", err, terr)
+			_, truncSpan := startSpan(ctx, "AppendBatchSize.truncate", attribute.Int64("microblob.offset", offset))
+			terr := os.Truncate(blobfn, offset)
+			endSpan(truncSpan, terr)
+			if terr != nil {
+				return &BackendError{Class: ErrIO, Err: fmt.Errorf("processing and truncate failed: %v, %v", err, terr)}
 			}
 		}
+		return err
+	}
+	// The data is stored, a missing fingerprint only shows in /readyz.
+	if fp, ok := backend.(Fingerprinter); ok {
+		if err := fp.SaveFingerprint(); err != nil {
+			log.Printf("save fingerprint: %v", err)
+		}
+	}
+	return nil
+}
+
+// checkPreconditions compares the current version of each key in a file with
+// a list of entity tags.
+func checkPreconditions(ctx context.Context, fn string, backend Backend, kf KeyFunc, etags []string) (err error) {
+	_, span := startSpan(ctx, "checkPreconditions")
+	defer func() { endSpan(span, err) }()
+	lookuper, ok := backend.(Lookuper)
+	if !ok {
+		return fmt.Errorf("backend does not support conditional updates")
+	}
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	for {
+		b, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(b)) == 0 {
+			continue
+		}
+		key, err := kf(b)
+		if err != nil {
+			return err
+		}
+		entry, err := lookuper.Lookup(key)
+		switch {
+		case err == ErrNotFound:
+			return &PreconditionError{Key: key}
+		case err != nil:
+			return err
+		case !matchETag(etags, entry.ETag()):
+			return &PreconditionError{Key: key, ETag: entry.ETag()}
+		}
+	}
+	return nil
+}
+
+// matchETag reports, whether a current entity tag matches a list of tags, as
+// found in an If-Match header, using strong comparison. Tags of compressed
+// representations match the stored representation.
+func matchETag(etags []string, current string) bool {
+	for _, t := range etags {
+		if t == "*" || stripEncodingETag(t) == current {
+			return true
+		}
 	}
-	return err
+	return false
 }
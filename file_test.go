@@ -0,0 +1,30 @@
+package microblob
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// failingFingerprinter cannot save its fingerprint.
+type failingFingerprinter struct {
+	DebugBackend
+}
+
+func (failingFingerprinter) SaveFingerprint() error {
+	return errors.New("disk full")
+}
+
+func TestAppendIgnoresFingerprintError(t *testing.T) {
+	blobfile := filepath.Join(t.TempDir(), "blob.ndjson")
+	if err := os.WriteFile(blobfile, []byte(testDocuments), 0644); err != nil {
+		t.Fatal(err)
+	}
+	backend := failingFingerprinter{DebugBackend{Writer: io.Discard}}
+	extractor := ParsingExtractor{Key: "id"}
+	if err := AppendBatchSize(blobfile, "", backend, extractor.ExtractKey, 2, false); err != nil {
+		t.Fatalf("append of stored data failed: %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package microblob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/miku/microblob/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCServer implements the gRPC interface defined in rpc/microblob.proto on
+// top of a backend.
+type GRPCServer struct {
+	rpc.UnimplementedMicroblobServer
+	Backend  Backend
+	Blobfile string
+	ReadOnly bool // reject updates
+}
+
+// NewGRPCServer returns a gRPC server with the microblob service registered.
+// With readOnly, updates are rejected.
+func NewGRPCServer(backend Backend, blobfile string, readOnly bool, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(opts...)
+	rpc.RegisterMicroblobServer(s, &GRPCServer{Backend: backend, Blobfile: blobfile, ReadOnly: readOnly})
+	return s
+}
+
+// Get returns a single document.
+func (s *GRPCServer) Get(ctx context.Context, req *rpc.GetRequest) (*rpc.Document, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	doc, err := s.document(ctx, req.Key)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return doc, nil
+}
+
+// BatchGet streams documents in request order, missing keys are reported as
+// not found, other errors end the stream.
+func (s *GRPCServer) BatchGet(req *rpc.BatchGetRequest, stream rpc.Microblob_BatchGetServer) error {
+	for _, key := range req.Keys {
+		doc, err := s.document(stream.Context(), key)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			doc = &rpc.Document{Key: key}
+		case err != nil:
+			return grpcError(err)
+		}
+		if err := stream.Send(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Exists reports, which of the given keys are present.
+func (s *GRPCServer) Exists(ctx context.Context, req *rpc.ExistsRequest) (*rpc.ExistsResponse, error) {
+	resp := &rpc.ExistsResponse{Exists: make(map[string]bool, len(req.Keys))}
+	for _, key := range req.Keys {
+		ok, err := exists(s.Backend, key)
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		resp.Exists[key] = ok
+	}
+	return resp, nil
+}
+
+// Count returns the number of keys, if the backend supports it.
+func (s *GRPCServer) Count(ctx context.Context, req *rpc.CountRequest) (*rpc.CountResponse, error) {
+	c, ok := s.Backend.(Counter)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "backend does not support count")
+	}
+	n, err := c.Count()
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &rpc.CountResponse{Count: n}, nil
+}
+
+// Update appends the streamed documents, like a POST to /update.
+func (s *GRPCServer) Update(stream rpc.Microblob_UpdateServer) error {
+	if s.ReadOnly {
+		return status.Error(codes.PermissionDenied, ErrReadOnly.Error())
+	}
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if req.KeyField == "" {
+		return status.Error(codes.InvalidArgument, "key field is required")
+	}
+	var (
+		extractor = ParsingExtractor{Key: req.KeyField}
+		etags     = req.IfMatch
+	)
+	f, err := ioutil.TempFile("", "microblob-")
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	var last byte
+	for {
+		if len(req.Data) > 0 {
+			if _, err := f.Write(req.Data); err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			last = req.Data[len(req.Data)-1]
+		}
+		if req, err = stream.Recv(); err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if last != 0 && last != '\n' {
+		if _, err := f.Write([]byte("\n")); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	if err := f.Close(); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if err := AppendIfMatch(stream.Context(), s.Blobfile, f.Name(), s.Backend, extractor.ExtractKey, etags); err != nil {
+		return grpcAppendError(err)
+	}
+	return stream.SendAndClose(&rpc.UpdateResponse{})
+}
+
+// document retrieves a document along with its entity tag, if available.
+func (s *GRPCServer) document(ctx context.Context, key string) (*rpc.Document, error) {
+	entry, err := lookupEntry(ctx, s.Backend, key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := readDocument(ctx, s.Backend, key, entry)
+	if err != nil {
+		return nil, err
+	}
+	doc := &rpc.Document{Key: key, Data: b, Found: true}
+	if entry != nil {
+		doc.Etag = entry.ETag()
+	}
+	return doc, nil
+}
+
+// grpcError maps a backend error to a gRPC status, like writeBackendError
+// does for HTTP. Unclassified errors are internal.
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrCorrupt):
+		return status.Error(codes.DataLoss, err.Error())
+	case errors.Is(err, ErrClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// grpcAppendError maps an error from a (conditional) append to a gRPC status,
+// like writeAppendError does for HTTP. Only documents without usable key are
+// blamed on the request.
+func grpcAppendError(err error) error {
+	var (
+		pe *PreconditionError
+		ke *KeyError
+	)
+	switch {
+	case errors.As(err, &pe):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.As(err, &ke):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrReadOnly):
+		return status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return grpcError(err)
+	}
+}
+
+// GRPCServerOptions returns interceptors, that apply the auth rules to gRPC
+// calls: Update requires write scope, other calls read scope, if required.
+// Only bearer tokens are supported, sent as authorization metadata.
+func (a *Auth) GRPCServerOptions() []grpc.ServerOption {
+	if a == nil {
+		return nil
+	}
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler) (interface{}, error) {
+			if err := a.authorizeGRPC(ctx, info.FullMethod); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+			handler grpc.StreamHandler) error {
+			if err := a.authorizeGRPC(ss.Context(), info.FullMethod); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	}
+}
+
+// RequireClientCertGRPC returns a server option, that restricts gRPC updates
+// to clients with a verified TLS client certificate, like RequireClientCert
+// does for HTTP write routes.
+func RequireClientCertGRPC() grpc.ServerOption {
+	return grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		if info.FullMethod == rpc.Microblob_Update_FullMethodName && !hasVerifiedClientCert(ss.Context()) {
+			return status.Error(codes.PermissionDenied, errClientCert.Error())
+		}
+		return handler(srv, ss)
+	})
+}
+
+// hasVerifiedClientCert reports, whether the peer of a call presented a
+// client certificate, that has been verified.
+func hasVerifiedClientCert(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	return ok && len(info.State.VerifiedChains) > 0
+}
+
+// authorizeGRPC checks the bearer token of a call.
+func (a *Auth) authorizeGRPC(ctx context.Context, method string) error {
+	scope := ScopeRead
+	if method == rpc.Microblob_Update_FullMethodName {
+		scope = ScopeWrite
+	}
+	if scope == ScopeRead && !a.RequireRead {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	if v := md.Get("authorization"); len(v) > 0 {
+		token = strings.TrimSpace(strings.TrimPrefix(v[0], "Bearer "))
+	}
+	cred, err := a.token(token)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	if cred.Scope < scope {
+		return status.Error(codes.PermissionDenied, errForbidden.Error())
+	}
+	return nil
+}
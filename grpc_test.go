@@ -0,0 +1,336 @@
+package microblob
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miku/microblob/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testDocuments are indexed by newTestBackend, keyed by id.
+const testDocuments = `{"id": "a", "v": 1}
+{"id": "b", "v": 2}
+{"id": "c", "v": 3}
+`
+
+// newTestBackend builds a database for testDocuments in a temporary
+// directory and returns the backend and the blob file name.
+func newTestBackend(t *testing.T) (*LevelDBBackend, string) {
+	t.Helper()
+	dir := t.TempDir()
+	blobfile := filepath.Join(dir, "blob.ndjson")
+	if err := os.WriteFile(blobfile, []byte(testDocuments), 0644); err != nil {
+		t.Fatal(err)
+	}
+	backend := &LevelDBBackend{Blobfile: blobfile, Filename: filepath.Join(dir, "blob.db")}
+	t.Cleanup(func() { backend.Close() })
+	extractor := ParsingExtractor{Key: "id"}
+	if err := AppendBatchSize(blobfile, "", backend, extractor.ExtractKey, 2, false); err != nil {
+		t.Fatal(err)
+	}
+	return backend, blobfile
+}
+
+// startGRPC serves a GRPCServer on an in-process listener.
+func startGRPC(t *testing.T, srv *GRPCServer, opts ...grpc.ServerOption) *bufconn.Listener {
+	t.Helper()
+	ln := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(opts...)
+	rpc.RegisterMicroblobServer(server, srv)
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+	return ln
+}
+
+// dialGRPC returns a client connected to an in-process listener.
+func dialGRPC(t *testing.T, ln *bufconn.Listener, creds credentials.TransportCredentials) rpc.MicroblobClient {
+	t.Helper()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return ln.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return rpc.NewMicroblobClient(conn)
+}
+
+// update sends documents through the Update stream.
+func update(ctx context.Context, client rpc.MicroblobClient, data string, ifMatch ...string) error {
+	stream, err := client.Update(ctx)
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(&rpc.UpdateRequest{KeyField: "id", IfMatch: ifMatch, Data: []byte(data)}); err != nil {
+		return err
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+// testCertificates returns a server certificate, a client certificate and
+// the pool of the CA, that signed both.
+func testCertificates(t *testing.T) (server, client tls.Certificate, pool *x509.CertPool) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(ca)
+	issue := func(serial int64, usage x509.ExtKeyUsage) tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "localhost"},
+			DNSNames:     []string{"localhost"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+	return issue(2, x509.ExtKeyUsageServerAuth), issue(3, x509.ExtKeyUsageClientAuth), pool
+}
+
+func TestGRPCUpdateRequiresClientCert(t *testing.T) {
+	backend, blobfile := newTestBackend(t)
+	serverCert, clientCert, pool := testCertificates(t)
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+	ln := startGRPC(t, &GRPCServer{Backend: backend, Blobfile: blobfile},
+		grpc.Creds(credentials.NewTLS(serverConfig)), RequireClientCertGRPC())
+	ctx := context.Background()
+
+	anonymous := dialGRPC(t, ln, credentials.NewTLS(&tls.Config{RootCAs: pool, ServerName: "localhost"}))
+	if _, err := anonymous.Get(ctx, &rpc.GetRequest{Key: "a"}); err != nil {
+		t.Fatalf("get without client certificate: %v", err)
+	}
+	err := update(ctx, anonymous, `{"id": "d"}`)
+	if got := status.Code(err); got != codes.PermissionDenied {
+		t.Fatalf("update without client certificate: got %v, want %v", got, codes.PermissionDenied)
+	}
+
+	authenticated := dialGRPC(t, ln, credentials.NewTLS(&tls.Config{
+		RootCAs:      pool,
+		ServerName:   "localhost",
+		Certificates: []tls.Certificate{clientCert},
+	}))
+	if err := update(ctx, authenticated, `{"id": "d"}`); err != nil {
+		t.Fatalf("update with client certificate: %v", err)
+	}
+
+	plain := dialGRPC(t, startGRPC(t, &GRPCServer{Backend: backend, Blobfile: blobfile}, RequireClientCertGRPC()),
+		insecure.NewCredentials())
+	err = update(ctx, plain, `{"id": "e"}`)
+	if got := status.Code(err); got != codes.PermissionDenied {
+		t.Fatalf("update without TLS: got %v, want %v", got, codes.PermissionDenied)
+	}
+}
+
+func TestGRPCServer(t *testing.T) {
+	backend, blobfile := newTestBackend(t)
+	client := dialGRPC(t, startGRPC(t, &GRPCServer{Backend: backend, Blobfile: blobfile}), insecure.NewCredentials())
+	ctx := context.Background()
+
+	doc, err := client.Get(ctx, &rpc.GetRequest{Key: "b"})
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if !doc.Found || string(doc.Data) != "{\"id\": \"b\", \"v\": 2}\n" || doc.Etag == "" {
+		t.Fatalf("get: got %+v", doc)
+	}
+	if _, err := client.Get(ctx, &rpc.GetRequest{Key: "x"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("get missing: got %v, want %v", err, codes.NotFound)
+	}
+
+	stream, err := client.BatchGet(ctx, &rpc.BatchGetRequest{Keys: []string{"c", "x", "a"}})
+	if err != nil {
+		t.Fatalf("batch get: %v", err)
+	}
+	var got []string
+	for {
+		doc, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("batch get: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%s:%v", doc.Key, doc.Found))
+	}
+	if want := []string{"c:true", "x:false", "a:true"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("batch get: got %v, want %v", got, want)
+	}
+
+	resp, err := client.Exists(ctx, &rpc.ExistsRequest{Keys: []string{"a", "x"}})
+	if err != nil {
+		t.Fatalf("exists: %v", err)
+	}
+	if want := map[string]bool{"a": true, "x": false}; !reflect.DeepEqual(resp.Exists, want) {
+		t.Fatalf("exists: got %v, want %v", resp.Exists, want)
+	}
+
+	count, err := client.Count(ctx, &rpc.CountRequest{})
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if count.Count != 3 {
+		t.Fatalf("count: got %d, want 3", count.Count)
+	}
+
+	// Conditional updates: the current entity tag matches, the previous
+	// one does not anymore.
+	a, err := client.Get(ctx, &rpc.GetRequest{Key: "a"})
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if err := update(ctx, client, `{"id": "a", "v": 10}`, a.Etag); err != nil {
+		t.Fatalf("update with matching etag: %v", err)
+	}
+	err = update(ctx, client, `{"id": "a", "v": 11}`, a.Etag)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("update with stale etag: got %v, want %v", err, codes.FailedPrecondition)
+	}
+	if err := update(ctx, client, `{"id": "d", "v": 4}`); err != nil {
+		t.Fatalf("unconditional update: %v", err)
+	}
+	doc, err = client.Get(ctx, &rpc.GetRequest{Key: "a"})
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if string(doc.Data) != "{\"id\": \"a\", \"v\": 10}\n" {
+		t.Fatalf("get after update: got %q", doc.Data)
+	}
+	if count, err = client.Count(ctx, &rpc.CountRequest{}); err != nil || count.Count != 4 {
+		t.Fatalf("count after update: got %v, %v, want 4", count, err)
+	}
+}
+
+func TestGRPCServerReadOnly(t *testing.T) {
+	backend, blobfile := newTestBackend(t)
+	client := dialGRPC(t, startGRPC(t, &GRPCServer{Backend: backend, Blobfile: blobfile, ReadOnly: true}),
+		insecure.NewCredentials())
+	err := update(context.Background(), client, `{"id": "d"}`)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("update: got %v, want %v", err, codes.PermissionDenied)
+	}
+	if _, err := client.Get(context.Background(), &rpc.GetRequest{Key: "a"}); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+}
+
+func TestGRPCErrorCodes(t *testing.T) {
+	var cases = []struct {
+		err  error
+		want codes.Code
+	}{
+		{ErrNotFound, codes.NotFound},
+		{&BackendError{Class: ErrCorrupt, Err: errors.New("bad crc")}, codes.DataLoss},
+		{&BackendError{Class: ErrIO, Err: errors.New("disk")}, codes.Internal},
+		{ErrClosed, codes.Unavailable},
+		{errors.New("unclassified"), codes.Internal},
+	}
+	for _, c := range cases {
+		if got := status.Code(grpcError(c.err)); got != c.want {
+			t.Errorf("grpcError(%v): got %v, want %v", c.err, got, c.want)
+		}
+	}
+	var appendCases = []struct {
+		err  error
+		want codes.Code
+	}{
+		{&PreconditionError{Key: "a"}, codes.FailedPrecondition},
+		{&KeyError{Err: errors.New("key id not found")}, codes.InvalidArgument},
+		{ErrReadOnly, codes.PermissionDenied},
+		{&BackendError{Class: ErrIO, Err: errors.New("truncate failed")}, codes.Internal},
+		{ErrClosed, codes.Unavailable},
+		{errors.New("unclassified"), codes.Internal},
+	}
+	for _, c := range appendCases {
+		if got := status.Code(grpcAppendError(c.err)); got != c.want {
+			t.Errorf("grpcAppendError(%v): got %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestGRPCUpdateErrors(t *testing.T) {
+	backend, blobfile := newTestBackend(t)
+	client := dialGRPC(t, startGRPC(t, &GRPCServer{Backend: backend, Blobfile: blobfile}), insecure.NewCredentials())
+	ctx := context.Background()
+	if err := update(ctx, client, `{"no": "key"}`); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("update without key: got %v, want %v", err, codes.InvalidArgument)
+	}
+	backend.ReadOnly = true
+	if err := update(ctx, client, `{"id": "d"}`); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("update on read-only backend: got %v, want %v", err, codes.PermissionDenied)
+	}
+	backend.ReadOnly = false
+	backend.Close()
+	if err := update(ctx, client, `{"id": "d"}`); status.Code(err) != codes.Unavailable {
+		t.Fatalf("update on closed backend: got %v, want %v", err, codes.Unavailable)
+	}
+}
+
+func TestNewGRPCServerReadOnly(t *testing.T) {
+	backend, blobfile := newTestBackend(t)
+	ln := bufconn.Listen(1 << 20)
+	server := NewGRPCServer(backend, blobfile, true)
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+	client := dialGRPC(t, ln, insecure.NewCredentials())
+	if err := update(context.Background(), client, `{"id": "d"}`); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("update: got %v, want %v", err, codes.PermissionDenied)
+	}
+}
@@ -1,22 +1,42 @@
 package microblob
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"expvar"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/miku/microblob/api"
+	"github.com/segmentio/encoding/json"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var (
 	okCounter        *expvar.Int
 	errCounter       *expvar.Int
+	notFoundCounter  *expvar.Int
+	corruptCounter   *expvar.Int
+	ioErrorCounter   *expvar.Int
+	closedCounter    *expvar.Int
+	internalCounter  *expvar.Int
 	lastResponseTime *expvar.Float
 )
 
+// errorResponse is the body of a failed lookup.
+type errorResponse struct {
+	Err string `json:"error"`
+	Key string `json:"key,omitempty"`
+}
+
 // finalNewlineReader appends a final newline to a byte stream, but only if there is not already one.
 type finalNewlineReader struct {
 	r    io.Reader
@@ -51,40 +71,539 @@ func WithLastResponseTime(h http.Handler) http.Handler {
 	return http.HandlerFunc(f)
 }
 
-// BlobHandler serves blobs.
+// BlobHandler serves blobs. If CacheControl is set, it is sent as
+// Cache-Control header along with every document. If HotKeys is set, requests
+// are tracked there.
 type BlobHandler struct {
-	Backend Backend
+	Backend      Backend
+	CacheControl string
+	HotKeys      *HotKeys
 }
 
-// ServeHTTP serves HTTP.
+// ServeHTTP serves HTTP. If the backend supports entry lookups, responses
+// carry validators and conditional requests are answered from the index alone.
 func (h *BlobHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	traceHandler("BlobHandler.ServeHTTP", http.HandlerFunc(h.serve)).ServeHTTP(w, r)
+}
+
+// serve looks up and serves a document.
+func (h *BlobHandler) serve(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("X-Blob", Version)
 	w.Header().Set("Content-Type", "application/json")
 	vars := mux.Vars(r)
 	key, ok := vars["key"]
-	if !ok || key == "blob/" {
+	if !ok && r.URL.Path == api.RouteLookup {
+		// Keys, that are reserved or do not survive path cleaning, as escaped
+		// key parameter.
+		key, ok = r.URL.Query().Get("key"), true
+		if key == "" {
+			writeError(w, http.StatusBadRequest, "", fmt.Errorf("key is required"))
+			errCounter.Add(1)
+			return
+		}
+	} else if !ok || key == "blob/" {
 		// From https://tools.ietf.org/html/rfc3986#section-3.4: [...] However, as query
 		// components are often used to carry identifying information in the form of
 		// "key=value" pairs [...]
 		//
-		// Legacy route with the key as value.
+		// Legacy route with the key as value. The raw query is used as is, so
+		// existing clients get the same keys.
 		key = r.URL.RawQuery
 		if key == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(`key is required`))
+			writeError(w, http.StatusBadRequest, "", fmt.Errorf("key is required"))
 			errCounter.Add(1)
 			return
 		}
 	}
+	noteKey(r, key)
+	// On the legacy route, the query is the key, not parameters.
+	if fields := ParseFields(r.URL.Query().Get("fields")); ok && len(fields) > 0 {
+		h.serveProjection(w, r, key, fields)
+		return
+	}
+	entry, err := lookupEntry(r.Context(), h.Backend, key)
+	if err != nil {
+		h.HotKeys.Observe(key, 0, err)
+		writeBackendError(w, r, key, err)
+		return
+	}
+	var modified time.Time
+	if entry != nil {
+		modified = lastModified(h.Backend)
+		if notModified(r, entry.ETag(), modified) {
+			w.Header().Del("Content-Type")
+			h.setValidators(w, entry, modified)
+			// Lets compression decide on the entity tag like for 200.
+			w.Header().Set("Content-Length", strconv.FormatInt(entry.Length, 10))
+			w.WriteHeader(http.StatusNotModified)
+			h.HotKeys.Observe(key, -1, nil)
+			okCounter.Add(1)
+			return
+		}
+		if r.Method == "HEAD" {
+			// Answered from the index, the blob file is not touched.
+			h.setValidators(w, entry, modified)
+			w.Header().Set("Content-Length", strconv.FormatInt(entry.Length, 10))
+			// Lets compression adjust the headers, as it would for GET.
+			w.WriteHeader(http.StatusOK)
+			h.HotKeys.Observe(key, -1, nil)
+			okCounter.Add(1)
+			return
+		}
+		if h.serveRange(w, r, entry, modified) {
+			return
+		}
+	}
+	b, err := readDocument(r.Context(), h.Backend, key, entry)
+	h.HotKeys.Observe(key, int64(len(b)), err)
+	if err != nil {
+		writeBackendError(w, r, key, err)
+		return
+	}
+	if entry != nil {
+		h.setValidators(w, entry, modified)
+		w.Header().Set("Content-Length", strconv.FormatInt(entry.Length, 10))
+	}
+	w.Write(b)
+	okCounter.Add(1)
+}
+
+// serveProjection serves a document reduced to the given fields. Since the
+// response differs from the stored document, no validators are sent.
+func (h *BlobHandler) serveProjection(w http.ResponseWriter, r *http.Request, key string, fields []string) {
 	b, err := h.Backend.Get(key)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		_, _ = w.Write([]byte(err.Error()))
+		h.HotKeys.Observe(key, 0, err)
+		writeBackendError(w, r, key, err)
+		return
+	}
+	if b, err = Project(b, fields); err != nil {
+		h.HotKeys.Observe(key, -1, nil)
+		writeError(w, http.StatusUnprocessableEntity, key, err)
 		errCounter.Add(1)
 		return
 	}
+	if h.CacheControl != "" {
+		w.Header().Set("Cache-Control", h.CacheControl)
+	}
+	h.HotKeys.Observe(key, int64(len(b)), nil)
+	w.Write(b)
+	okCounter.Add(1)
+}
+
+// serveRange serves a single byte range of a document, if requested and
+// supported by the backend. Returns false, if the full document should be
+// served instead, e.g. for multiple ranges or if If-Range does not match.
+func (h *BlobHandler) serveRange(w http.ResponseWriter, r *http.Request, entry *Entry, modified time.Time) bool {
+	reader, ok := h.Backend.(RangeReader)
+	if !ok {
+		return false
+	}
+	spec := r.Header.Get("Range")
+	if spec == "" || r.Method != "GET" {
+		return false
+	}
+	// Ranges are served uncompressed, so only the identity entity tag may
+	// match; a tag of an encoded representation gets the full document.
+	if ir := r.Header.Get("If-Range"); ir != "" && ir != entry.ETag() {
+		return false
+	}
+	start, length, err := parseRange(spec, entry.Length)
+	if err == errIgnoreRange {
+		return false
+	}
+	if err != nil {
+		h.setValidators(w, entry, modified)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", entry.Length))
+		writeError(w, http.StatusRequestedRangeNotSatisfiable, entry.Key, err)
+		h.HotKeys.Observe(entry.Key, -1, nil)
+		errCounter.Add(1)
+		return true
+	}
+	_, span := startSpan(r.Context(), "Backend.ReadEntryRange", attribute.String("microblob.key", entry.Key),
+		attribute.Int64("microblob.offset", entry.Offset+start), attribute.Int64("microblob.length", length))
+	started := time.Now()
+	b, err := reader.ReadEntryRange(*entry, start, length)
+	observeBackend("read", started)
+	endSpan(span, err)
+	h.HotKeys.Observe(entry.Key, int64(len(b)), err)
+	if err != nil {
+		writeBackendError(w, r, entry.Key, err)
+		return true
+	}
+	h.setValidators(w, entry, modified)
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, entry.Length))
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(http.StatusPartialContent)
 	w.Write(b)
 	okCounter.Add(1)
+	return true
+}
+
+// errIgnoreRange signals a Range header, that should be ignored.
+var errIgnoreRange = errors.New("ignore range")
+
+// parseRange parses a single byte range as described in RFC 7233, section
+// 2.1, into start and length. Returns errIgnoreRange for headers, that are
+// invalid or request multiple ranges, which may be ignored.
+func parseRange(spec string, size int64) (start, length int64, err error) {
+	if !strings.HasPrefix(spec, "bytes=") || strings.Contains(spec, ",") {
+		return 0, 0, errIgnoreRange
+	}
+	spec = strings.TrimSpace(strings.TrimPrefix(spec, "bytes="))
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, 0, errIgnoreRange
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if first == "" {
+		// Suffix range, the last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, errIgnoreRange
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, fmt.Errorf("range not satisfiable")
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, errIgnoreRange
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, errIgnoreRange
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, fmt.Errorf("range not satisfiable")
+	}
+	return start, end - start + 1, nil
+}
+
+// setValidators sets entity tag, modification time and caching policy.
+func (h *BlobHandler) setValidators(w http.ResponseWriter, entry *Entry, modified time.Time) {
+	w.Header().Set("ETag", entry.ETag())
+	if _, ok := h.Backend.(RangeReader); ok {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if h.CacheControl != "" {
+		w.Header().Set("Cache-Control", h.CacheControl)
+	}
+}
+
+// writeBackendError maps a backend error to a status code, writes it and
+// counts it by class. Unclassified errors are internal server errors.
+func writeBackendError(w http.ResponseWriter, r *http.Request, key string, err error) {
+	errCounter.Add(1)
+	if rec := recordOf(r); rec != nil {
+		rec.errorClass = errorClass(err)
+	}
+	switch {
+	case errors.Is(err, ErrCorrupt):
+		corruptCounter.Add(1)
+		writeError(w, http.StatusInternalServerError, key, err)
+	case errors.Is(err, ErrIO):
+		ioErrorCounter.Add(1)
+		writeError(w, http.StatusInternalServerError, key, err)
+	case errors.Is(err, ErrClosed):
+		closedCounter.Add(1)
+		writeError(w, http.StatusServiceUnavailable, key, err)
+	case errors.Is(err, ErrNotFound):
+		notFoundCounter.Add(1)
+		writeError(w, http.StatusNotFound, key, err)
+	default:
+		internalCounter.Add(1)
+		writeError(w, http.StatusInternalServerError, key, err)
+	}
+}
+
+// errMethodNotAllowed is sent along with an Allow header.
+var errMethodNotAllowed = errors.New("method not allowed")
+
+// writeError writes an error as JSON.
+func writeError(w http.ResponseWriter, status int, key string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(errorResponse{Err: err.Error(), Key: key})
+}
+
+// lookupEntry returns the index entry for a key, if the backend supports entry
+// lookups and reads; the entry is nil otherwise.
+func lookupEntry(ctx context.Context, backend Backend, key string) (entry *Entry, err error) {
+	lookuper, ok := backend.(Lookuper)
+	if !ok {
+		return nil, nil
+	}
+	if _, ok := backend.(EntryReader); !ok {
+		return nil, nil
+	}
+	_, span := startSpan(ctx, "Backend.Lookup", attribute.String("microblob.key", key))
+	defer func() { endSpan(span, err) }()
+	started := time.Now()
+	e, err := lookuper.Lookup(key)
+	observeBackend("lookup", started)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int64("microblob.offset", e.Offset), attribute.Int64("microblob.length", e.Length))
+	return &e, nil
+}
+
+// readDocument reads a document through its entry, if there is one, which
+// ensures, that validators and document belong together.
+func readDocument(ctx context.Context, backend Backend, key string, entry *Entry) (b []byte, err error) {
+	started := time.Now()
+	if entry == nil {
+		_, span := startSpan(ctx, "Backend.Get", attribute.String("microblob.key", key))
+		defer func() { endSpan(span, err) }()
+		defer observeBackend("get", started)
+		return backend.Get(key)
+	}
+	_, span := startSpan(ctx, "Backend.ReadEntry", attribute.String("microblob.key", key),
+		attribute.Int64("microblob.offset", entry.Offset), attribute.Int64("microblob.length", entry.Length))
+	defer func() { endSpan(span, err) }()
+	defer observeBackend("read", started)
+	return backend.(EntryReader).ReadEntry(*entry)
+}
+
+// lastModified returns the modification time of the backend, if known.
+func lastModified(backend Backend) time.Time {
+	m, ok := backend.(ModTimer)
+	if !ok {
+		return time.Time{}
+	}
+	t, err := m.ModTime()
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// notModified evaluates If-None-Match and, only in its absence,
+// If-Modified-Since, as described in RFC 7232, section 6.
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, t := range parseETags(inm) {
+			if t == "*" || stripEncodingETag(strings.TrimPrefix(t, "W/")) == etag {
+				return true
+			}
+		}
+		return false
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || modified.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(t)
+}
+
+// parseETags splits the value of an If-Match or If-None-Match header into
+// entity tags.
+func parseETags(s string) (etags []string) {
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			etags = append(etags, t)
+		}
+	}
+	return etags
+}
+
+// spool copies a request body into a temporary file, making sure it ends with
+// a newline. The caller is responsible for removing the file.
+func spool(r io.Reader) (string, error) {
+	f, err := ioutil.TempFile("", "microblob-")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, &finalNewlineReader{r: r}); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("temporary copy failed: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("temporary file close failed: %v", err)
+	}
+	return f.Name(), nil
+}
+
+// writeAppendError writes an error from a (conditional) append as JSON.
+// Failing preconditions and documents without usable key are blamed on the
+// request, anything else is a backend error.
+func writeAppendError(w http.ResponseWriter, r *http.Request, key string, err error) {
+	var (
+		pe *PreconditionError
+		ke *KeyError
+	)
+	switch {
+	case errors.As(err, &pe):
+		writeError(w, http.StatusPreconditionFailed, pe.Key, err)
+	case errors.As(err, &ke):
+		writeError(w, http.StatusBadRequest, key, fmt.Errorf("append: %w", err))
+	case errors.Is(err, ErrReadOnly):
+		writeError(w, http.StatusForbidden, key, err)
+	default:
+		writeBackendError(w, r, key, err)
+	}
+}
+
+// ExistsHandler checks, which of a list of keys are present.
+type ExistsHandler struct {
+	Backend Backend
+}
+
+// ServeHTTP reads a JSON array of keys from the POST body and responds with a
+// JSON object mapping each key to its presence.
+func (h ExistsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "", errMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+	var keys []string
+	if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+		writeError(w, http.StatusBadRequest, "", fmt.Errorf("exists: expected JSON array of keys: %v", err))
+		return
+	}
+	result := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		ok, err := exists(h.Backend, key)
+		if err != nil {
+			writeBackendError(w, r, key, err)
+			return
+		}
+		result[key] = ok
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// exists reports whether a key is present, using the index only, if possible.
+// As in writeBackendError, unclassified errors count as not found.
+func exists(backend Backend, key string) (bool, error) {
+	var err error
+	if lookuper, ok := backend.(Lookuper); ok {
+		_, err = lookuper.Lookup(key)
+	} else {
+		_, err = backend.Get(key)
+	}
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrNotFound):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+const (
+	defaultKeysLimit = 1000   // keys per page, if not specified
+	maxKeysLimit     = 100000 // keys per page, at most
+)
+
+// KeysHandler lists keys in order, optionally restricted by prefix and start.
+type KeysHandler struct {
+	Backend Backend
+}
+
+// keysResponse is a page of keys and the cursor to the next page, if any.
+type keysResponse struct {
+	Keys []string `json:"keys"`
+	Next string   `json:"next,omitempty"`
+}
+
+// ServeHTTP lists keys as JSON. With docs=true, the documents are written
+// instead, newline delimited, reduced to the paths given in fields, if any.
+// In both cases, the cursor for the next page is sent in an X-Next-Key
+// header; pass it as start to continue.
+func (h KeysHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	scanner, ok := h.Backend.(Scanner)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "", fmt.Errorf("backend does not support scans"))
+		return
+	}
+	q := r.URL.Query()
+	opts := ScanOptions{
+		Prefix: q.Get("prefix"),
+		Start:  q.Get("start"),
+		Limit:  defaultKeysLimit,
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxKeysLimit {
+			writeError(w, http.StatusBadRequest, "", fmt.Errorf("limit must be between 1 and %d", maxKeysLimit))
+			return
+		}
+		opts.Limit = limit
+	}
+	var entries []Entry
+	next, err := scanner.Scan(opts, func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		writeBackendError(w, r, "", err)
+		return
+	}
+	if next != "" {
+		w.Header().Set("X-Next-Key", next)
+	}
+	if withDocs, _ := strconv.ParseBool(q.Get("docs")); withDocs {
+		reader, ok := h.Backend.(EntryReader)
+		if !ok {
+			writeError(w, http.StatusNotImplemented, "", fmt.Errorf("backend does not support entry reads"))
+			return
+		}
+		fields := ParseFields(q.Get("fields"))
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for _, e := range entries {
+			b, err := reader.ReadEntry(e)
+			if err == nil && len(fields) > 0 {
+				b, err = Project(b, fields)
+			}
+			if err != nil {
+				// Headers are gone, best we can do is stop.
+				return
+			}
+			if _, err := w.Write(b); err != nil {
+				return
+			}
+		}
+		return
+	}
+	resp := keysResponse{Keys: make([]string, 0, len(entries)), Next: next}
+	for _, e := range entries {
+		resp.Keys = append(resp.Keys, e.Key)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 }
 
 // UpdateHandler adds more data to the blob server.
@@ -93,45 +612,121 @@ type UpdateHandler struct {
 	Backend  Backend
 }
 
-// ServeHTTP appends data from POST body to existing blob file.
+// ServeHTTP appends data from POST body to existing blob file. With an
+// If-Match header, the update only happens, if every key in the body is
+// currently at one of the given versions.
 func (u UpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	traceHandler("UpdateHandler.ServeHTTP", http.HandlerFunc(u.serve)).ServeHTTP(w, r)
+}
+
+// serve spools and appends the body.
+func (u UpdateHandler) serve(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "", errMethodNotAllowed)
 		return
 	}
 	key := r.URL.Query().Get("key")
 	if key == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("update: key query parameter required"))
+		writeError(w, http.StatusBadRequest, "", errors.New("update: key query parameter required"))
 		return
 	}
 	extractor := ParsingExtractor{Key: key}
-	f, err := ioutil.TempFile("", "microblob-")
+	defer r.Body.Close()
+	filename, err := spool(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		writeError(w, http.StatusInternalServerError, "", fmt.Errorf("update: %w", err))
 		return
 	}
-	if _, err := io.Copy(f, &finalNewlineReader{r: r.Body}); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("temporary copy failed: " + err.Error()))
+	defer os.Remove(filename)
+	etags := parseETags(r.Header.Get("If-Match"))
+	if err := AppendIfMatch(r.Context(), u.Blobfile, filename, u.Backend, extractor.ExtractKey, etags); err != nil {
+		writeAppendError(w, r, "", err)
 		return
 	}
+}
+
+// PutHandler stores the request body as a single document under the key given
+// in the URL. Supports If-Match for conditional updates.
+type PutHandler struct {
+	Blobfile string
+	Backend  Backend
+}
+
+// ServeHTTP appends data from PUT body to existing blob file. Since the blob
+// file is newline delimited, a body spanning multiple lines is only accepted,
+// if it is JSON, which gets compacted into a single line.
+func (u PutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	traceHandler("PutHandler.ServeHTTP", http.HandlerFunc(u.serve)).ServeHTTP(w, r)
+}
+
+// serve stores the body under the key.
+func (u PutHandler) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		w.Header().Set("Allow", "PUT")
+		writeError(w, http.StatusMethodNotAllowed, "", errMethodNotAllowed)
+		return
+	}
+	key := mux.Vars(r)["key"]
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "", errors.New("put: key required"))
+		return
+	}
+	noteKey(r, key)
 	defer r.Body.Close()
-	defer os.Remove(f.Name())
-	if err := f.Close(); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("temporary file close failed: " + err.Error()))
+	doc, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, key, fmt.Errorf("put: %w", err))
+		return
+	}
+	if doc, err = singleLine(doc); err != nil {
+		writeError(w, http.StatusBadRequest, key, fmt.Errorf("put: %w", err))
+		return
+	}
+	filename, err := spool(bytes.NewReader(doc))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, key, fmt.Errorf("put: %w", err))
+		return
+	}
+	defer os.Remove(filename)
+	etags := parseETags(r.Header.Get("If-Match"))
+	entry, created, err := AppendDocument(r.Context(), u.Blobfile, filename, key, u.Backend, etags)
+	if err != nil {
+		writeAppendError(w, r, key, err)
+		return
 	}
-	if err := Append(u.Blobfile, f.Name(), u.Backend, extractor.ExtractKey); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("append: " + err.Error()))
+	w.Header().Set("ETag", entry.ETag())
+	if created {
+		w.WriteHeader(http.StatusCreated)
 		return
 	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// singleLine returns a document as a single line without trailing whitespace.
+// Multi-line JSON is compacted, other multi-line content is rejected.
+func singleLine(doc []byte) ([]byte, error) {
+	doc = bytes.TrimRight(doc, " \t\r\n")
+	if len(doc) == 0 {
+		return nil, fmt.Errorf("empty document")
+	}
+	if bytes.IndexByte(doc, '\n') == -1 {
+		return doc, nil
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, doc); err != nil {
+		return nil, fmt.Errorf("document spans multiple lines and is not JSON: %v", err)
+	}
+	return buf.Bytes(), nil
 }
 
 func init() {
 	okCounter = expvar.NewInt("okCounter")
 	errCounter = expvar.NewInt("errCounter")
+	notFoundCounter = expvar.NewInt("notFoundCounter")
+	corruptCounter = expvar.NewInt("corruptCounter")
+	ioErrorCounter = expvar.NewInt("ioErrorCounter")
+	closedCounter = expvar.NewInt("closedCounter")
+	internalCounter = expvar.NewInt("internalCounter")
 	lastResponseTime = expvar.NewFloat("lastResponseTime")
 }
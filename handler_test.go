@@ -0,0 +1,158 @@
+package microblob
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/segmentio/encoding/json"
+)
+
+func TestIfRangeRequiresIdentityETag(t *testing.T) {
+	backend, blobfile := newTestBackend(t)
+	ts := httptest.NewServer(NewHandler(backend, blobfile))
+	defer ts.Close()
+	entry, err := backend.Lookup("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases = []struct {
+		ifRange string
+		status  int
+	}{
+		{entry.ETag(), http.StatusPartialContent},
+		{encodingETag(entry.ETag(), "gzip"), http.StatusOK},
+		{encodingETag(entry.ETag(), "zstd"), http.StatusOK},
+		{`"0-00000000"`, http.StatusOK},
+	}
+	for _, c := range cases {
+		req, err := http.NewRequest("GET", ts.URL+"/a", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Range", "bytes=0-4")
+		req.Header.Set("If-Range", c.ifRange)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("If-Range %s: got status %d, want %d (%q)", c.ifRange, resp.StatusCode, c.status, b)
+		}
+	}
+}
+
+func TestAppendErrors(t *testing.T) {
+	backend, blobfile := newTestBackend(t)
+	ts := httptest.NewServer(NewHandler(backend, blobfile))
+	defer ts.Close()
+	do := func(method, path, body string, header ...string) (int, errorResponse) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var e errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+			t.Fatalf("%s %s: expected JSON error: %v", method, path, err)
+		}
+		return resp.StatusCode, e
+	}
+	if status, e := do("POST", "/update?key=id", `{"no": "key"}`+"\n"); status != http.StatusBadRequest || e.Err == "" {
+		t.Errorf("update without key: got %d %+v, want 400", status, e)
+	}
+	if status, e := do("PUT", "/a", `{"id": "a"}`, "If-Match", `"0-00000000"`); status != http.StatusPreconditionFailed || e.Key != "a" {
+		t.Errorf("stale put: got %d %+v, want 412 with key", status, e)
+	}
+	backend.ReadOnly = true
+	if status, e := do("PUT", "/a", `{"id": "a"}`); status != http.StatusForbidden || e.Key != "a" {
+		t.Errorf("put on read-only backend: got %d %+v, want 403", status, e)
+	}
+	backend.ReadOnly = false
+	backend.Close()
+	if status, e := do("PUT", "/a", `{"id": "a"}`); status != http.StatusServiceUnavailable || e.Key != "a" {
+		t.Errorf("put on closed backend: got %d %+v, want 503", status, e)
+	}
+}
+
+// failingBackend fails every lookup with an unclassified error.
+type failingBackend struct {
+	DebugBackend
+}
+
+func (failingBackend) Get(key string) ([]byte, error) {
+	return nil, errors.New("something unexpected")
+}
+
+func TestUnclassifiedErrors(t *testing.T) {
+	ts := httptest.NewServer(NewHandler(failingBackend{}, ""))
+	defer ts.Close()
+	var cases = []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", "/a", "", http.StatusInternalServerError},
+		{"POST", "/exists", `["a"]`, http.StatusInternalServerError},
+		{"GET", "/count", "", http.StatusNotImplemented},
+		{"GET", "/update", "", http.StatusMethodNotAllowed},
+		{"POST", "/update", "", http.StatusBadRequest},
+		{"GET", "/exists", "", http.StatusMethodNotAllowed},
+	}
+	for _, c := range cases {
+		req, err := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var e errorResponse
+		err = json.NewDecoder(resp.Body).Decode(&e)
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("%s %s: got status %d, want %d", c.method, c.path, resp.StatusCode, c.status)
+		}
+		if ct := resp.Header.Get("Content-Type"); err != nil || e.Err == "" || ct != "application/json" {
+			t.Errorf("%s %s: expected JSON error, got %q (%v)", c.method, c.path, ct, err)
+		}
+	}
+}
+
+func TestProjectionRoutes(t *testing.T) {
+	backend, blobfile := newTestBackend(t)
+	ts := httptest.NewServer(NewHandler(backend, blobfile))
+	defer ts.Close()
+	var cases = []struct {
+		path, want string
+	}{
+		{"/a?fields=v", `{"v":1}`},
+		{"/lookup?key=a&fields=v", `{"v":1}`},
+		{"/lookup?key=a", `{"id": "a", "v": 1}`},
+		{"/blob?a", `{"id": "a", "v": 1}`},
+	}
+	for _, c := range cases {
+		resp, err := http.Get(ts.URL + c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got := strings.TrimSpace(string(b)); resp.StatusCode != http.StatusOK || got != c.want {
+			t.Errorf("%s: got %d %s, want %s", c.path, resp.StatusCode, got, c.want)
+		}
+	}
+}
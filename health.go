@@ -0,0 +1,184 @@
+package microblob
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// Checker can verify, that a backend is able to serve requests.
+type Checker interface {
+	Check() error
+}
+
+// Fingerprinter can remember the state of the blob file after an update, so
+// Check can tell, whether database and blob file still belong together.
+type Fingerprinter interface {
+	SaveFingerprint() error
+}
+
+// fingerprintTail is the number of bytes at the end of the blob file, that
+// are checksummed.
+const fingerprintTail = 65536
+
+// fingerprint identifies a blob file by size and the checksum of its last
+// bytes. This is cheap to compute and changes with every append.
+type fingerprint struct {
+	Size     int64  `json:"size"`
+	Checksum uint32 `json:"checksum"`
+}
+
+// fingerprintOf computes the fingerprint of a file with a given size.
+func fingerprintOf(f *os.File, size int64) (fingerprint, error) {
+	offset := size - fingerprintTail
+	if offset < 0 {
+		offset = 0
+	}
+	h := crc32.New(crcTable)
+	if _, err := io.Copy(h, io.NewSectionReader(f, offset, size-offset)); err != nil {
+		return fingerprint{}, err
+	}
+	return fingerprint{Size: size, Checksum: h.Sum32()}, nil
+}
+
+// SaveFingerprint stores the fingerprint of the blob file in the database
+// directory, along with the other metadata.
+func (b *LevelDBBackend) SaveFingerprint() error {
+	if b.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := b.openDatabase(); err != nil {
+		return err
+	}
+	f, err := os.Open(b.Blobfile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fp, err := fingerprintOf(f, fi.Size())
+	if err != nil {
+		return err
+	}
+	b.metaMu.Lock()
+	defer b.metaMu.Unlock()
+	if _, err := b.stats(); err != nil {
+		return err
+	}
+	now := time.Now()
+	b.meta.Blob, b.meta.Updated = fp, &now
+	data, err := json.Marshal(b.meta)
+	if err != nil {
+		return err
+	}
+	filename := filepath.Join(b.Filename, metaFile)
+	if err := os.WriteFile(filename+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// Check verifies, that the database is open, the blob file is readable and
+// has not been replaced since it was opened, and that it still starts with
+// the data the stored fingerprint describes. Appends, also those in progress
+// or not yet fingerprinted, leave that data alone and do not affect reads, so
+// they pass. Databases created by earlier versions have no fingerprint.
+func (b *LevelDBBackend) Check() error {
+	if err := b.openDatabase(); err != nil {
+		return fmt.Errorf("database: %w", err)
+	}
+	if err := b.openBlob(); err != nil {
+		return fmt.Errorf("blob file: %w", err)
+	}
+	fi, err := os.Stat(b.Blobfile)
+	if err != nil {
+		return fmt.Errorf("blob file: %w", err)
+	}
+	opened, err := b.blob.Stat()
+	if err != nil {
+		return fmt.Errorf("blob file: %w", err)
+	}
+	if !os.SameFile(fi, opened) {
+		return fmt.Errorf("blob file has been replaced, restart to serve it")
+	}
+	// Read from disk, the database may be updated by another process.
+	meta, err := readMeta(b.Filename)
+	if err != nil {
+		return fmt.Errorf("fingerprint: %w", err)
+	}
+	want := meta.Blob
+	if want == (fingerprint{}) {
+		return nil
+	}
+	if fi.Size() < want.Size {
+		return fmt.Errorf("blob file does not match database: size %d, want at least %d", fi.Size(), want.Size)
+	}
+	got, err := fingerprintOf(b.blob, want.Size)
+	if err != nil {
+		return fmt.Errorf("blob file: %w", err)
+	}
+	if got != want {
+		return fmt.Errorf("blob file does not match database: checksum %08x at size %d, want %08x",
+			got.Checksum, want.Size, want.Checksum)
+	}
+	return nil
+}
+
+// ReadyHandler reports, whether the backend can serve requests, with 503
+// Service Unavailable otherwise.
+type ReadyHandler struct {
+	Backend Backend
+	Keys    []string // sample keys, that must be readable
+}
+
+// readyResponse lists the result of each check, "ok" or an error message.
+type readyResponse struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// ServeHTTP runs the checks: the backend check, if the backend implements
+// Checker, and whether the sample keys can be read, checksums included.
+func (h ReadyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resp := readyResponse{Ready: true, Checks: make(map[string]string)}
+	report := func(name string, err error) {
+		if err != nil {
+			resp.Ready = false
+			resp.Checks[name] = err.Error()
+			return
+		}
+		resp.Checks[name] = "ok"
+	}
+	if c, ok := h.Backend.(Checker); ok {
+		report("backend", c.Check())
+	}
+	for _, key := range h.Keys {
+		entry, err := lookupEntry(r.Context(), h.Backend, key)
+		if err == nil {
+			_, err = readDocument(r.Context(), h.Backend, key, entry)
+		}
+		report("key:"+key, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !resp.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// healthHandler reports, that the process is alive.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
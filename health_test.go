@@ -0,0 +1,50 @@
+package microblob
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestCheckAllowsAppends(t *testing.T) {
+	backend, blobfile := newTestBackend(t)
+	if err := backend.Check(); err != nil {
+		t.Fatalf("check after build: %v", err)
+	}
+	// An append, whose index and fingerprint are not written yet.
+	f, err := os.OpenFile(blobfile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("{\"id\": \"d\"}\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := backend.Check(); err != nil {
+		t.Fatalf("check during append: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	ReadyHandler{Backend: backend, Keys: []string{"a"}}.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("readyz during append: got %d, %s", rec.Code, rec.Body)
+	}
+	// Modified or truncated data does not match anymore.
+	b, err := os.ReadFile(blobfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(testDocuments)-3] = '9'
+	if err := os.WriteFile(blobfile, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Check(); err == nil {
+		t.Fatal("check after modification: expected error")
+	}
+	if err := os.Truncate(blobfile, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Check(); err == nil {
+		t.Fatal("check after truncation: expected error")
+	}
+}
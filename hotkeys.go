@@ -0,0 +1,204 @@
+package microblob
+
+import (
+	"errors"
+	"hash/maphash"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/segmentio/encoding/json"
+)
+
+const (
+	sketchDepth = 4    // rows of the count-min sketch
+	sketchWidth = 4096 // counters per row
+)
+
+// sizeBuckets are the upper bounds of the document size histogram, larger
+// documents are counted in an extra bucket.
+var sizeBuckets = []int64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216}
+
+// HotKeys finds frequently requested keys. A sample of requests is counted in
+// a count-min sketch, which estimates the frequency of any key in fixed
+// memory; the N keys with the highest estimates are kept. Request, miss and
+// document size counts include all requests. A nil tracker does nothing.
+type HotKeys struct {
+	N          int     // number of hot keys to report
+	SampleRate float64 // fraction of requests counted in the sketch
+
+	requests int64
+	misses   int64
+	errors   int64
+	sizes    []int64 // histogram, one more than len(sizeBuckets)
+
+	mu      sync.Mutex
+	sampled int64
+	seeds   [sketchDepth]maphash.Seed
+	sketch  [sketchDepth][sketchWidth]uint32
+	top     map[string]uint32 // estimates of the hottest keys seen
+}
+
+// NewHotKeys returns a tracker for the n hottest keys, counting the given
+// fraction of requests, all if the rate is not between zero and one.
+func NewHotKeys(n int, sampleRate float64) *HotKeys {
+	if sampleRate <= 0 || sampleRate > 1 {
+		sampleRate = 1
+	}
+	t := &HotKeys{
+		N:          n,
+		SampleRate: sampleRate,
+		sizes:      make([]int64, len(sizeBuckets)+1),
+		top:        make(map[string]uint32),
+	}
+	for i := range t.seeds {
+		t.seeds[i] = maphash.MakeSeed()
+	}
+	return t
+}
+
+// Observe records a request for a key, with the size of the document served
+// or a negative size, if no document has been sent, e.g. for HEAD requests.
+// Missing keys count as misses, but are sampled like any other key.
+func (t *HotKeys) Observe(key string, size int64, err error) {
+	if t == nil {
+		return
+	}
+	atomic.AddInt64(&t.requests, 1)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		atomic.AddInt64(&t.misses, 1)
+	case err != nil:
+		atomic.AddInt64(&t.errors, 1)
+	case size >= 0:
+		i := sort.Search(len(sizeBuckets), func(i int) bool { return sizeBuckets[i] >= size })
+		atomic.AddInt64(&t.sizes[i], 1)
+	}
+	if t.SampleRate < 1 && rand.Float64() >= t.SampleRate {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sampled++
+	var est uint32
+	for i := range t.sketch {
+		c := &t.sketch[i][maphash.String(t.seeds[i], key)%sketchWidth]
+		*c++
+		if i == 0 || *c < est {
+			est = *c
+		}
+	}
+	if _, ok := t.top[key]; ok || len(t.top) < t.N {
+		t.top[key] = est
+		return
+	}
+	// Replace the coldest of the hot keys, if this one is hotter.
+	var (
+		coldest string
+		lowest  uint32
+	)
+	for k, v := range t.top {
+		if coldest == "" || v < lowest {
+			coldest, lowest = k, v
+		}
+	}
+	if est > lowest {
+		delete(t.top, coldest)
+		t.top[key] = est
+	}
+}
+
+// Reset forgets all observations.
+func (t *HotKeys) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	atomic.StoreInt64(&t.requests, 0)
+	atomic.StoreInt64(&t.misses, 0)
+	atomic.StoreInt64(&t.errors, 0)
+	for i := range t.sizes {
+		atomic.StoreInt64(&t.sizes[i], 0)
+	}
+	t.sampled = 0
+	t.sketch = [sketchDepth][sketchWidth]uint32{}
+	t.top = make(map[string]uint32)
+}
+
+// hotKey is a key with its estimated number of requests, extrapolated from
+// the sample.
+type hotKey struct {
+	Key      string `json:"key"`
+	Requests int64  `json:"requests"`
+}
+
+// sizeBucket counts documents up to a size, the last bucket has no bound.
+type sizeBucket struct {
+	LE    int64 `json:"le,omitempty"`
+	Count int64 `json:"count"`
+}
+
+// hotKeysReport is served by the admin endpoint.
+type hotKeysReport struct {
+	Requests   int64        `json:"requests"`
+	Sampled    int64        `json:"sampled"`
+	SampleRate float64      `json:"sample_rate"`
+	Misses     int64        `json:"misses"`
+	MissRate   float64      `json:"miss_rate"`
+	Errors     int64        `json:"errors"`
+	Top        []hotKey     `json:"top"`
+	Sizes      []sizeBucket `json:"sizes"`
+}
+
+// report summarizes the observations, hottest keys first.
+func (t *HotKeys) report() hotKeysReport {
+	r := hotKeysReport{
+		Requests:   atomic.LoadInt64(&t.requests),
+		SampleRate: t.SampleRate,
+		Misses:     atomic.LoadInt64(&t.misses),
+		Errors:     atomic.LoadInt64(&t.errors),
+		Top:        []hotKey{},
+	}
+	if r.Requests > 0 {
+		r.MissRate = float64(r.Misses) / float64(r.Requests)
+	}
+	for i := range t.sizes {
+		b := sizeBucket{Count: atomic.LoadInt64(&t.sizes[i])}
+		if i < len(sizeBuckets) {
+			b.LE = sizeBuckets[i]
+		}
+		r.Sizes = append(r.Sizes, b)
+	}
+	t.mu.Lock()
+	r.Sampled = t.sampled
+	for k, v := range t.top {
+		r.Top = append(r.Top, hotKey{Key: k, Requests: int64(float64(v) / t.SampleRate)})
+	}
+	t.mu.Unlock()
+	sort.Slice(r.Top, func(i, j int) bool {
+		if r.Top[i].Requests == r.Top[j].Requests {
+			return r.Top[i].Key < r.Top[j].Key
+		}
+		return r.Top[i].Requests > r.Top[j].Requests
+	})
+	return r
+}
+
+// ServeHTTP reports hot keys, miss rate and document sizes as JSON. DELETE
+// resets the tracker.
+func (t *HotKeys) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+	case "DELETE":
+		t.Reset()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", "GET, HEAD, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(t.report())
+}
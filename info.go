@@ -0,0 +1,289 @@
+package microblob
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// FormatVersion is the version of the index format written: version 2 entries
+// carry a checksum, version 1 entries do not.
+const FormatVersion = 2
+
+// metaFile is kept in the database directory, LevelDB ignores it.
+const metaFile = "MICROBLOB-META"
+
+// statsKey holds the index statistics inside LevelDB, written in the same
+// batch as the entries, so both stay consistent, even if an update fails
+// halfway. Keys starting with a NUL byte are reserved, they cannot be
+// indexed and are skipped by Scan.
+const statsKey = "\x00microblob:stats"
+
+// isReserved returns true for keys, that are not document keys.
+func isReserved(key []byte) bool {
+	return len(key) > 0 && key[0] == 0
+}
+
+// dbMeta is stored along with the database and rewritten after each update.
+// Databases created by earlier versions have none until they are updated, so
+// creation time and format version remain unknown for them. Stats are kept
+// in LevelDB, see statsKey.
+type dbMeta struct {
+	FormatVersion int         `json:"format_version,omitempty"`
+	Created       *time.Time  `json:"created,omitempty"`
+	Updated       *time.Time  `json:"updated,omitempty"`
+	Extractor     string      `json:"extractor,omitempty"`
+	Blob          fingerprint `json:"blob"`
+	Stats         *indexStats `json:"-"`
+}
+
+// indexStats summarize the documents currently indexed. They are computed by
+// a full scan once and then maintained with each write. Minimum and maximum
+// size only ever widen: when the smallest or largest document is replaced,
+// finding the next one would require a scan, so they are bounds rather than
+// exact values after updates.
+type indexStats struct {
+	Keys      int64 `json:"keys"`
+	LiveBytes int64 `json:"live_bytes"`
+	MinSize   int64 `json:"min_size"`
+	MaxSize   int64 `json:"max_size"`
+}
+
+// add accounts for a new document of a given size.
+func (s *indexStats) add(size int64) {
+	if s.Keys == 0 || size < s.MinSize {
+		s.MinSize = size
+	}
+	if size > s.MaxSize {
+		s.MaxSize = size
+	}
+	s.Keys++
+	s.LiveBytes += size
+}
+
+// Info describes blob file and index.
+type Info struct {
+	FormatVersion int        `json:"format_version,omitempty"`
+	Created       *time.Time `json:"created,omitempty"` // time of the initial build
+	Updated       *time.Time `json:"updated,omitempty"` // time of the last update
+	Extractor     string     `json:"extractor,omitempty"`
+	Blobfile      string     `json:"blobfile"`
+	BlobSize      int64      `json:"blob_size"`
+	LiveBytes     int64      `json:"live_bytes"` // size of the documents currently indexed
+	DeadBytes     int64      `json:"dead_bytes"` // size of replaced documents and unindexed lines
+	Keys          int64      `json:"keys"`
+	AvgSize       float64    `json:"avg_size"`
+	MinSize       int64      `json:"min_size"`
+	MaxSize       int64      `json:"max_size"`
+	Database      string     `json:"database"`
+	DatabaseSize  int64      `json:"database_size"` // on disk
+}
+
+// Informer can describe its data.
+type Informer interface {
+	Info() (Info, error)
+}
+
+// loadMeta reads the metadata of the database. For a new database, metadata
+// is started with the current version and extractor; stats are not
+// maintained during the initial build, but computed by a single scan
+// afterwards, which is cheaper than looking up every key. Must be called with
+// metaMu held.
+func (b *LevelDBBackend) loadMeta(created bool) error {
+	if created {
+		now := time.Now()
+		b.meta = &dbMeta{FormatVersion: FormatVersion, Created: &now, Extractor: b.Extractor}
+		b.bulk = true
+		return nil
+	}
+	meta, err := readMeta(b.Filename)
+	if err != nil {
+		return err
+	}
+	b.meta = meta
+	return nil
+}
+
+// readMeta reads the metadata file of a database directory, which may not
+// exist.
+func readMeta(dir string) (*dbMeta, error) {
+	meta := new(dbMeta)
+	data, err := os.ReadFile(filepath.Join(dir, metaFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// stats returns the index statistics, as stored in the database. Databases
+// created by earlier versions have none, so the index is scanned once and,
+// unless read-only, the result is stored. Must be called with metaMu held.
+func (b *LevelDBBackend) stats() (*indexStats, error) {
+	if b.meta.Stats != nil {
+		return b.meta.Stats, nil
+	}
+	data, err := b.db.Get([]byte(statsKey), nil)
+	switch {
+	case err == nil:
+		s := new(indexStats)
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, &BackendError{Class: ErrCorrupt, Err: err}
+		}
+		b.meta.Stats = s
+		return s, nil
+	case err != leveldb.ErrNotFound:
+		return nil, classify(err)
+	}
+	s := new(indexStats)
+	iter := b.db.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if isReserved(iter.Key()) {
+			continue
+		}
+		entry, err := decodeEntry(string(iter.Key()), iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		s.add(entry.Length)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, classify(err)
+	}
+	if !b.ReadOnly {
+		data, err := json.Marshal(s)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.db.Put([]byte(statsKey), data, nil); err != nil {
+			return nil, classify(err)
+		}
+	}
+	b.meta.Stats, b.bulk = s, false
+	return s, nil
+}
+
+// updateStats accounts for entries about to be written, looking up the
+// entries they replace. Keys are looked up in order with a single iterator,
+// which is cheaper than a point read per key. Must be called with metaMu
+// held.
+func (b *LevelDBBackend) updateStats(s *indexStats, entries []Entry) error {
+	latest := make(map[string]int64, len(entries)) // last length per key
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if _, ok := latest[entry.Key]; !ok {
+			keys = append(keys, entry.Key)
+		}
+		latest[entry.Key] = entry.Length
+	}
+	sort.Strings(keys)
+	iter := b.db.NewIterator(nil, nil)
+	defer iter.Release()
+	for _, key := range keys {
+		if iter.Seek([]byte(key)) && string(iter.Key()) == key {
+			old, err := decodeEntry(key, iter.Value())
+			if err != nil {
+				return err
+			}
+			s.Keys--
+			s.LiveBytes -= old.Length
+		}
+		s.add(latest[key])
+	}
+	return classify(iter.Error())
+}
+
+// Info reports sizes and statistics. The first call may require a full scan
+// of the index, if the database has been created by an earlier version.
+func (b *LevelDBBackend) Info() (info Info, err error) {
+	if err = b.openDatabase(); err != nil {
+		return info, err
+	}
+	b.metaMu.Lock()
+	defer b.metaMu.Unlock()
+	s, err := b.stats()
+	if err != nil {
+		return info, err
+	}
+	info = Info{
+		FormatVersion: b.meta.FormatVersion,
+		Created:       b.meta.Created,
+		Updated:       b.meta.Updated,
+		Extractor:     b.meta.Extractor,
+		Blobfile:      b.Blobfile,
+		LiveBytes:     s.LiveBytes,
+		Keys:          s.Keys,
+		MinSize:       s.MinSize,
+		MaxSize:       s.MaxSize,
+		Database:      b.Filename,
+	}
+	if info.Extractor == "" {
+		info.Extractor = b.Extractor
+	}
+	if s.Keys > 0 {
+		info.AvgSize = float64(s.LiveBytes) / float64(s.Keys)
+	}
+	fi, err := os.Stat(b.Blobfile)
+	if err != nil {
+		return info, classify(err)
+	}
+	info.BlobSize = fi.Size()
+	info.DeadBytes = info.BlobSize - info.LiveBytes
+	if info.DatabaseSize, err = dirSize(b.Filename); err != nil {
+		return info, classify(err)
+	}
+	return info, nil
+}
+
+// dirSize returns the size of all files in a directory.
+func dirSize(dir string) (size int64, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += fi.Size()
+		return nil
+	})
+	return size, err
+}
+
+// InfoHandler serves information about blob file and index.
+type InfoHandler struct {
+	Backend Backend
+}
+
+// ServeHTTP responds with the backend info as JSON.
+func (h InfoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	informer, ok := h.Backend.(Informer)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "", errors.New("backend does not support info"))
+		return
+	}
+	info, err := informer.Info()
+	if err != nil {
+		writeBackendError(w, r, "", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(info)
+}
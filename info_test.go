@@ -0,0 +1,78 @@
+package microblob
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestStatsSurviveFailedUpdate(t *testing.T) {
+	backend, blobfile := newTestBackend(t)
+	// A batch written by an update, that failed before the fingerprint was
+	// saved.
+	if err := backend.WriteEntries([]Entry{{Key: "a", Offset: 0, Length: 19}, {Key: "d", Offset: 0, Length: 19}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened := &LevelDBBackend{Blobfile: blobfile, Filename: backend.Filename}
+	defer reopened.Close()
+	n, err := reopened.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatalf("count after reopen: got %d, want 4", n)
+	}
+	var keys []string
+	if _, err := reopened.Scan(ScanOptions{}, func(e Entry) error {
+		keys = append(keys, e.Key)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 4 {
+		t.Fatalf("scan: got %q, want 4 document keys", keys)
+	}
+	if _, err := reopened.Lookup(statsKey); err != ErrNotFound {
+		t.Fatalf("lookup of stats key: got %v, want not found", err)
+	}
+}
+
+func TestStatsDuringBuildAndUpdates(t *testing.T) {
+	dir := t.TempDir()
+	backend := &LevelDBBackend{Blobfile: filepath.Join(dir, "blob.ndjson"), Filename: filepath.Join(dir, "blob.db")}
+	defer backend.Close()
+	// Initial build, with a key repeated across batches.
+	for _, batch := range [][]Entry{
+		{{Key: "a", Length: 10}, {Key: "b", Length: 20}},
+		{{Key: "a", Length: 30}, {Key: "c", Length: 40}},
+	} {
+		if err := backend.WriteEntries(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := backend.db.Get([]byte(statsKey), nil); err != leveldb.ErrNotFound {
+		t.Fatalf("stats written during the initial build: %v", err)
+	}
+	n, err := backend.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("count after build: got %d, want 3", n)
+	}
+	// Updates replace, add and repeat keys within a batch.
+	if err := backend.WriteEntries([]Entry{{Key: "b", Length: 5}, {Key: "d", Length: 1}, {Key: "d", Length: 7}}); err != nil {
+		t.Fatal(err)
+	}
+	want := indexStats{Keys: 4, LiveBytes: 30 + 5 + 40 + 7, MinSize: 5, MaxSize: 40}
+	if got := *backend.meta.Stats; got != want {
+		t.Fatalf("stats after update: got %+v, want %+v", got, want)
+	}
+	if _, err := backend.db.Get([]byte(statsKey), nil); err != nil {
+		t.Fatalf("stats not stored: %v", err)
+	}
+}
@@ -3,7 +3,9 @@ package microblob
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"reflect"
@@ -15,6 +17,7 @@ import (
 	"github.com/schollz/progressbar"
 	"github.com/segmentio/encoding/json"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // KeyExtractor extracts a string key from data.
@@ -25,6 +28,21 @@ type KeyExtractor interface {
 // KeyFunc extracts a key from a blob.
 type KeyFunc func([]byte) (string, error)
 
+// KeyError reports a document, for which no usable key could be extracted.
+// It is the fault of the input, not of the backend.
+type KeyError struct {
+	Offset int64 // of the document in the blob file
+	Err    error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
 // EntryWriter writes entries to some storage, e.g. a file or a database.
 type EntryWriter func(entries []Entry) error
 
@@ -36,7 +54,8 @@ type LineProcessor struct {
 	BatchSize         int         // number of lines in a batch
 	InitialOffset     int64       // allow offsets beside zero
 	Verbose           bool
-	IgnoreMissingKeys bool // skip document with missing keys
+	IgnoreMissingKeys bool            // skip document with missing keys
+	Context           context.Context // parent of trace spans, optional
 }
 
 // NewLineProcessor reads lines from the given reader, extracts the key with the
@@ -59,9 +78,29 @@ type workPackage struct {
 }
 
 // RunWithWorkers start processing the input, uses multiple workers.
-func (p LineProcessor) RunWithWorkers() error {
+func (p LineProcessor) RunWithWorkers() (err error) {
+	ctx := p.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := startSpan(ctx, "LineProcessor.RunWithWorkers", attribute.Int("microblob.batch_size", p.BatchSize))
+	defer func() { endSpan(span, err) }()
 	var (
+		errMu         sync.Mutex // protects processingErr
 		processingErr error
+		// fail records the first error, failed returns it.
+		fail = func(err error) {
+			errMu.Lock()
+			defer errMu.Unlock()
+			if processingErr == nil {
+				processingErr = err
+			}
+		}
+		failed = func() error {
+			errMu.Lock()
+			defer errMu.Unlock()
+			return processingErr
+		}
 		// Setup communication channels.
 		work    = make(chan workPackage)
 		updates = make(chan []Entry)
@@ -69,11 +108,14 @@ func (p LineProcessor) RunWithWorkers() error {
 		// collector runs the EntryWriter on all incoming batches.
 		collector = func(ch chan []Entry, done chan bool) {
 			for batch := range ch {
-				if err := p.w(batch); err != nil {
+				_, span := startSpan(ctx, "LineProcessor.write", attribute.Int("microblob.entries", len(batch)))
+				err := p.w(batch)
+				endSpan(span, err)
+				if err != nil {
 					if p.Verbose {
 						log.Printf("could not write batch: %v", err)
 					}
-					processingErr = err
+					fail(err)
 					break
 				}
 			}
@@ -84,8 +126,13 @@ func (p LineProcessor) RunWithWorkers() error {
 		worker = func(queue chan workPackage, wg *sync.WaitGroup) {
 			defer wg.Done()
 			for pkg := range queue {
+				_, span := startSpan(ctx, "LineProcessor.extract", attribute.Int64("microblob.offset", pkg.offset),
+					attribute.Int("microblob.documents", len(pkg.docs)))
 				offset := pkg.offset
-				var entries []Entry
+				var (
+					entries []Entry
+					werr    error
+				)
 				for _, b := range pkg.docs {
 					key, err := p.f(b)
 					if err != nil {
@@ -98,17 +145,21 @@ func (p LineProcessor) RunWithWorkers() error {
 								continue
 							}
 						}
-						processingErr = err
+						werr = &KeyError{Offset: offset, Err: err}
 						break
 					}
 					length := int64(len(b))
-					entries = append(entries, Entry{key, offset, length})
+					entries = append(entries, Entry{key, offset, length, crc32.Checksum(b, crcTable)})
 					offset += length
 				}
+				if werr != nil {
+					fail(werr)
+				}
+				endSpan(span, werr)
 				updates <- entries
-				if processingErr != nil {
+				if err := failed(); err != nil {
 					if p.Verbose {
-						log.Printf("worker failed: %v", processingErr)
+						log.Printf("worker failed: %v", err)
 					}
 					break
 				}
@@ -149,12 +200,12 @@ func (p LineProcessor) RunWithWorkers() error {
 			continue
 		}
 		if len(batch) == p.BatchSize {
-			if processingErr != nil {
+			if err := failed(); err != nil {
 				if p.Verbose {
-					log.Printf("stopping early due to processing err: %v", processingErr)
+					log.Printf("stopping early due to processing err: %v", err)
 				}
 				// XXX: leaks resources.
-				return processingErr
+				return err
 			}
 			bb := make([][]byte, len(batch))
 			copy(bb, batch)
@@ -179,7 +230,7 @@ func (p LineProcessor) RunWithWorkers() error {
 	wg.Wait()
 	close(updates)
 	<-done
-	return processingErr
+	return failed()
 }
 
 // RegexpExtractor extract a key via regular expression.
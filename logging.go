@@ -0,0 +1,138 @@
+package microblob
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxRequestIDLength limits request ids taken over from clients.
+const maxRequestIDLength = 128
+
+// accessRecord collects details about a request, that are only known deep
+// inside the handlers, for the access log.
+type accessRecord struct {
+	requestID  string
+	key        string
+	errorClass string
+	identity   string
+}
+
+// recordOf returns the access record of a request, or nil, if the request is
+// not logged.
+func recordOf(r *http.Request) *accessRecord {
+	rec, _ := r.Context().Value(accessRecordKey).(*accessRecord)
+	return rec
+}
+
+// noteKey records the key a request asked for.
+func noteKey(r *http.Request, key string) {
+	if rec := recordOf(r); rec != nil {
+		rec.key = key
+	}
+}
+
+// noteIdentity records the credential, that authenticated a request.
+func noteIdentity(r *http.Request, id string) {
+	if rec := recordOf(r); rec != nil {
+		rec.identity = id
+	}
+}
+
+// RequestID returns the id of a request, as sent in the X-Request-Id
+// header, or the empty string, if the request is not logged.
+func RequestID(r *http.Request) string {
+	if rec := recordOf(r); rec != nil {
+		return rec.requestID
+	}
+	return ""
+}
+
+// errorClass names the class of a backend error, as counted in expvar.
+// Unclassified errors count as not found, as in writeBackendError.
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrCorrupt):
+		return "corrupt"
+	case errors.Is(err, ErrIO):
+		return "io"
+	case errors.Is(err, ErrClosed):
+		return "closed"
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	default:
+		return "internal"
+	}
+}
+
+// WithAccessLog logs every request as a single entry with method, URI,
+// status, bytes sent and latency in seconds, as well as the requested key,
+// the class of a backend error and the credential, if any. Each request gets
+// an id, which is taken from the X-Request-Id header, if the client sent one,
+// and returned in the same header.
+func WithAccessLog(logger *log.Logger, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		rec := &accessRecord{requestID: requestID(r)}
+		w.Header().Set("X-Request-Id", rec.requestID)
+		sr := &statusRecorder{ResponseWriter: w}
+		r = r.WithContext(context.WithValue(r.Context(), accessRecordKey, rec))
+		h.ServeHTTP(sr, r)
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		fields := log.Fields{
+			"request_id": rec.requestID,
+			"remote":     host,
+			"method":     r.Method,
+			"uri":        r.RequestURI,
+			"proto":      r.Proto,
+			"status":     sr.status,
+			"bytes":      sr.bytes,
+			"latency":    time.Since(started).Seconds(),
+			"user_agent": r.UserAgent(),
+		}
+		if rec.key != "" {
+			fields["key"] = rec.key
+		}
+		if rec.errorClass != "" {
+			fields["error_class"] = rec.errorClass
+		}
+		if rec.identity != "" {
+			fields["user"] = rec.identity
+		}
+		logger.WithFields(fields).Info("request")
+	})
+}
+
+// requestID returns the id sent by the client, if it is reasonably short
+// and printable, or a new random one.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); id != "" && len(id) <= maxRequestIDLength {
+		printable := true
+		for i := 0; i < len(id); i++ {
+			if id[i] <= ' ' || id[i] > '~' {
+				printable = false
+				break
+			}
+		}
+		if printable {
+			return id
+		}
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
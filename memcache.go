@@ -0,0 +1,193 @@
+package microblob
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MemcacheServer implements the read subset of the memcached text protocol:
+// get, gets, version, stats and quit. Storage commands are rejected, so
+// legacy memcached clients can read from microblob without code changes.
+type MemcacheServer struct {
+	Backend     Backend
+	IdleTimeout time.Duration // close idle connections, default five minutes
+
+	started          time.Time
+	currConnections  int64
+	totalConnections int64
+	cmdGet           int64
+	getHits          int64
+	getMisses        int64
+}
+
+const (
+	// maxMemcacheLine is the maximum length of a command line, as in memcached.
+	maxMemcacheLine = 2048
+	// defaultMemcacheIdleTimeout applies, if IdleTimeout is not set.
+	defaultMemcacheIdleTimeout = 5 * time.Minute
+)
+
+// storageCommands are followed by a data block, which is skipped.
+var storageCommands = map[string]bool{
+	"set": true, "add": true, "replace": true, "append": true, "prepend": true, "cas": true,
+}
+
+// Serve accepts connections on a listener and serves each in a goroutine.
+func (s *MemcacheServer) Serve(ln net.Listener) error {
+	s.started = time.Now()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn handles commands on a single connection, until the client quits
+// or the connection fails.
+func (s *MemcacheServer) ServeConn(conn net.Conn) {
+	defer conn.Close()
+	atomic.AddInt64(&s.currConnections, 1)
+	atomic.AddInt64(&s.totalConnections, 1)
+	defer atomic.AddInt64(&s.currConnections, -1)
+	var (
+		br      = bufio.NewReaderSize(conn, maxMemcacheLine)
+		bw      = bufio.NewWriter(conn)
+		timeout = s.IdleTimeout
+	)
+	if timeout == 0 {
+		timeout = defaultMemcacheIdleTimeout
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		line, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			bw.WriteString("CLIENT_ERROR line too long\r\n")
+			bw.Flush()
+			return
+		}
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, os.ErrDeadlineExceeded) {
+				log.Printf("memcache: %v", err)
+			}
+			return
+		}
+		fields := strings.Fields(string(line))
+		if len(fields) == 0 {
+			bw.WriteString("ERROR\r\n")
+			bw.Flush()
+			continue
+		}
+		switch cmd := fields[0]; {
+		case cmd == "get" || cmd == "gets":
+			if len(fields) < 2 {
+				bw.WriteString("ERROR\r\n")
+				break
+			}
+			s.get(bw, fields[1:], cmd == "gets")
+		case cmd == "version":
+			fmt.Fprintf(bw, "VERSION %s\r\n", Version)
+		case cmd == "stats":
+			s.stats(bw)
+		case cmd == "quit":
+			bw.Flush()
+			return
+		case storageCommands[cmd]:
+			// <cmd> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply],
+			// a data block follows. Without a valid length, the data block
+			// cannot be told from the next command, so the connection is closed.
+			var n int
+			if len(fields) >= 5 {
+				n, err = strconv.Atoi(fields[4])
+			}
+			if len(fields) < 5 || err != nil || n < 0 {
+				bw.WriteString("CLIENT_ERROR bad command line format\r\n")
+				bw.Flush()
+				return
+			}
+			if _, err := io.CopyN(io.Discard, br, int64(n)+2); err != nil {
+				return
+			}
+			if fields[len(fields)-1] == "noreply" {
+				continue
+			}
+			bw.WriteString("SERVER_ERROR read only\r\n")
+		default:
+			bw.WriteString("ERROR\r\n")
+		}
+		if err := bw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// get writes a VALUE line and data block for each key found. With cas, the
+// offset of the document in the blob file serves as the unique value, as it
+// changes with every update.
+func (s *MemcacheServer) get(w *bufio.Writer, keys []string, cas bool) {
+	for _, key := range keys {
+		atomic.AddInt64(&s.cmdGet, 1)
+		entry, err := lookupEntry(context.Background(), s.Backend, key)
+		var b []byte
+		if err == nil {
+			b, err = readDocument(context.Background(), s.Backend, key, entry)
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrNotFound):
+			atomic.AddInt64(&s.getMisses, 1)
+			continue
+		default:
+			fmt.Fprintf(w, "SERVER_ERROR %v\r\n", err)
+			return
+		}
+		atomic.AddInt64(&s.getHits, 1)
+		if cas {
+			var unique int64
+			if entry != nil {
+				unique = entry.Offset
+			}
+			fmt.Fprintf(w, "VALUE %s 0 %d %d\r\n", key, len(b), unique)
+		} else {
+			fmt.Fprintf(w, "VALUE %s 0 %d\r\n", key, len(b))
+		}
+		w.Write(b)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("END\r\n")
+}
+
+// stats writes general purpose statistics, a subset of what memcached reports.
+func (s *MemcacheServer) stats(w *bufio.Writer) {
+	now := time.Now()
+	stats := []struct {
+		name  string
+		value interface{}
+	}{
+		{"pid", os.Getpid()},
+		{"uptime", int64(now.Sub(s.started).Seconds())},
+		{"time", now.Unix()},
+		{"version", Version},
+		{"curr_connections", atomic.LoadInt64(&s.currConnections)},
+		{"total_connections", atomic.LoadInt64(&s.totalConnections)},
+		{"cmd_get", atomic.LoadInt64(&s.cmdGet)},
+		{"get_hits", atomic.LoadInt64(&s.getHits)},
+		{"get_misses", atomic.LoadInt64(&s.getMisses)},
+	}
+	for _, stat := range stats {
+		fmt.Fprintf(w, "STAT %s %v\r\n", stat.name, stat.value)
+	}
+	w.WriteString("END\r\n")
+}
@@ -0,0 +1,71 @@
+package microblob
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memcacheExchange sends raw protocol data to a MemcacheServer over an
+// in-memory connection and returns all reply lines, until the server closes
+// the connection or stops answering.
+func memcacheExchange(t *testing.T, request string) []string {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	s := &MemcacheServer{Backend: DebugBackend{Writer: io.Discard}}
+	go s.ServeConn(server)
+	go func() {
+		io.WriteString(client, request)
+	}()
+	client.SetDeadline(time.Now().Add(200 * time.Millisecond))
+	var lines []string
+	br := bufio.NewReader(client)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return lines
+		}
+		lines = append(lines, strings.TrimRight(line, "\r\n"))
+	}
+}
+
+func TestMemcacheStorageCommands(t *testing.T) {
+	version := "VERSION " + Version
+	var cases = []struct {
+		about   string
+		request string
+		want    []string
+	}{
+		{"rejected", "set k 0 0 5\r\nhello\r\nversion\r\n", []string{"SERVER_ERROR read only", version}},
+		{"noreply", "set k 0 0 5 noreply\r\nhello\r\nversion\r\n", []string{version}},
+		{"cas noreply", "cas k 0 0 5 1 noreply\r\nhello\r\nversion\r\n", []string{version}},
+		{"bad length closes", "set k 0 0 x\r\nversion\r\n", []string{"CLIENT_ERROR bad command line format"}},
+		{"missing length closes", "set k 0 0\r\nversion\r\n", []string{"CLIENT_ERROR bad command line format"}},
+		{"negative length closes", "set k 0 0 -1 noreply\r\nversion\r\n", []string{"CLIENT_ERROR bad command line format"}},
+	}
+	for _, c := range cases {
+		got := memcacheExchange(t, c.request)
+		if strings.Join(got, "|") != strings.Join(c.want, "|") {
+			t.Errorf("%s: got %q, want %q", c.about, got, c.want)
+		}
+	}
+}
+
+func TestMemcacheLimits(t *testing.T) {
+	got := memcacheExchange(t, "get "+strings.Repeat("k", maxMemcacheLine)+"\r\nversion\r\n")
+	if len(got) != 1 || got[0] != "CLIENT_ERROR line too long" {
+		t.Errorf("long line: got %q", got)
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	s := &MemcacheServer{Backend: DebugBackend{Writer: io.Discard}, IdleTimeout: 10 * time.Millisecond}
+	go s.ServeConn(server)
+	client.SetDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("idle connection: got %v, want EOF", err)
+	}
+}
@@ -0,0 +1,160 @@
+package microblob
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Prometheus metrics, shared by all handlers like the expvar counters.
+var (
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "microblob_http_request_duration_seconds",
+		Help:    "Latency of HTTP requests by route, method and status code.",
+		Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"route", "method", "code"})
+	responseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "microblob_http_response_size_bytes",
+		Help:    "Size of HTTP responses as sent, the sum is the number of bytes served.",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+	}, []string{"route", "method", "code"})
+	backendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "microblob_backend_duration_seconds",
+		Help:    "Latency of backend operations: index lookup, blob read or plain get.",
+		Buckets: []float64{.00001, .000025, .00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .1, 1},
+	}, []string{"op"})
+	updateDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "microblob_update_duration_seconds",
+		Help:    "Duration of update and build jobs by result.",
+		Buckets: prometheus.ExponentialBuckets(.001, 4, 12),
+	}, []string{"job", "result"})
+)
+
+// observeBackend records the duration of a backend operation.
+func observeBackend(op string, started time.Time) {
+	backendDuration.WithLabelValues(op).Observe(time.Since(started).Seconds())
+}
+
+// observeUpdate records an update job, the initial build has no file to
+// append from.
+func observeUpdate(fn string, started time.Time, err error) {
+	job, result := "update", "ok"
+	if fn == "" {
+		job = "build"
+	}
+	if err != nil {
+		result = "error"
+	}
+	updateDuration.WithLabelValues(job, result).Observe(time.Since(started).Seconds())
+}
+
+// instrument records latency and response size of a route.
+func instrument(route string, h http.Handler) http.Handler {
+	labels := prometheus.Labels{"route": route}
+	return promhttp.InstrumentHandlerDuration(requestDuration.MustCurryWith(labels),
+		promhttp.InstrumentHandlerResponseSize(responseSize.MustCurryWith(labels), h))
+}
+
+// newMetricsHandler returns a handler exposing request, backend and runtime
+// metrics. Backends implementing prometheus.Collector add their own.
+func newMetricsHandler(backend Backend) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestDuration,
+		responseSize,
+		backendDuration,
+		updateDuration,
+	)
+	if c, ok := backend.(prometheus.Collector); ok {
+		registry.MustRegister(c)
+	}
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+var (
+	blobSizeDesc = prometheus.NewDesc("microblob_blob_size_bytes",
+		"Size of the blob file.", nil, nil)
+	levelDBSizeDesc = prometheus.NewDesc("microblob_leveldb_size_bytes",
+		"Size of all LevelDB tables.", nil, nil)
+	levelDBTablesDesc = prometheus.NewDesc("microblob_leveldb_tables",
+		"Number of LevelDB tables per level.", []string{"level"}, nil)
+	levelDBIODesc = prometheus.NewDesc("microblob_leveldb_io_bytes_total",
+		"Bytes read and written by LevelDB.", []string{"direction"}, nil)
+	levelDBCompactionDesc = prometheus.NewDesc("microblob_leveldb_compaction_seconds_total",
+		"Time spent in LevelDB compactions.", nil, nil)
+	levelDBCompactionIODesc = prometheus.NewDesc("microblob_leveldb_compaction_bytes_total",
+		"Bytes read and written by LevelDB compactions.", []string{"direction"}, nil)
+	levelDBWriteDelayDesc = prometheus.NewDesc("microblob_leveldb_write_delay_seconds_total",
+		"Time writes were delayed by compactions.", nil, nil)
+	levelDBBlockCacheDesc = prometheus.NewDesc("microblob_leveldb_block_cache_bytes",
+		"Size of the LevelDB block cache.", nil, nil)
+	levelDBOpenTablesDesc = prometheus.NewDesc("microblob_leveldb_open_tables",
+		"Number of open LevelDB tables.", nil, nil)
+)
+
+// levelDBLevels is the number of levels reported, goleveldb uses seven.
+const levelDBLevels = 7
+
+// Describe implements prometheus.Collector.
+func (b *LevelDBBackend) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		blobSizeDesc, levelDBSizeDesc, levelDBTablesDesc, levelDBIODesc,
+		levelDBCompactionDesc, levelDBCompactionIODesc, levelDBWriteDelayDesc,
+		levelDBBlockCacheDesc, levelDBOpenTablesDesc,
+	} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector. LevelDB statistics are only
+// reported, once the database has been opened.
+func (b *LevelDBBackend) Collect(ch chan<- prometheus.Metric) {
+	if fi, err := os.Stat(b.Blobfile); err == nil {
+		ch <- prometheus.MustNewConstMetric(blobSizeDesc, prometheus.GaugeValue, float64(fi.Size()))
+	}
+	db := b.db
+	if db == nil {
+		return
+	}
+	var s leveldb.DBStats
+	if err := db.Stats(&s); err != nil {
+		return
+	}
+	var size int64
+	var compaction time.Duration
+	var compactionRead, compactionWrite int64
+	for i := range s.LevelSizes {
+		size += s.LevelSizes[i]
+		compaction += s.LevelDurations[i]
+		compactionRead += s.LevelRead[i]
+		compactionWrite += s.LevelWrite[i]
+	}
+	for level := 0; level < levelDBLevels; level++ {
+		v, err := db.GetProperty(fmt.Sprintf("leveldb.num-files-at-level%d", level))
+		if err != nil {
+			continue
+		}
+		var n float64
+		if _, err := fmt.Sscan(v, &n); err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(levelDBTablesDesc, prometheus.GaugeValue, n, fmt.Sprint(level))
+	}
+	ch <- prometheus.MustNewConstMetric(levelDBSizeDesc, prometheus.GaugeValue, float64(size))
+	ch <- prometheus.MustNewConstMetric(levelDBIODesc, prometheus.CounterValue, float64(s.IORead), "read")
+	ch <- prometheus.MustNewConstMetric(levelDBIODesc, prometheus.CounterValue, float64(s.IOWrite), "write")
+	ch <- prometheus.MustNewConstMetric(levelDBCompactionDesc, prometheus.CounterValue, compaction.Seconds())
+	ch <- prometheus.MustNewConstMetric(levelDBCompactionIODesc, prometheus.CounterValue, float64(compactionRead), "read")
+	ch <- prometheus.MustNewConstMetric(levelDBCompactionIODesc, prometheus.CounterValue, float64(compactionWrite), "write")
+	ch <- prometheus.MustNewConstMetric(levelDBWriteDelayDesc, prometheus.CounterValue, s.WriteDelayDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(levelDBBlockCacheDesc, prometheus.GaugeValue, float64(s.BlockCacheSize))
+	ch <- prometheus.MustNewConstMetric(levelDBOpenTablesDesc, prometheus.GaugeValue, float64(s.OpenedTablesCount))
+}
@@ -33,6 +33,7 @@ install -m 644 microblob.1.gz $RPM_BUILD_ROOT/usr/local/share/man/man1/microblob
 
 mkdir -p $RPM_BUILD_ROOT/usr/lib/systemd/system
 install -m 755 microblob.service $RPM_BUILD_ROOT/usr/lib/systemd/system
+install -m 644 microblob.socket $RPM_BUILD_ROOT/usr/lib/systemd/system
 
 mkdir -p $RPM_BUILD_ROOT/usr/local/share/microblob
 install -m 755 hello.ndjson $RPM_BUILD_ROOT/usr/local/share/microblob
@@ -54,6 +55,7 @@ rm -rf %{_topdir}/BUILD/%{name}
 %defattr(-,root,root)
 
 /usr/lib/systemd/system/microblob.service
+/usr/lib/systemd/system/microblob.socket
 /usr/local/bin/microblob
 /usr/local/share/man/man1/microblob.1.gz
 %config(noreplace) /etc/microblob/microblob.ini
@@ -0,0 +1,93 @@
+package microblob
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/segmentio/encoding/json"
+)
+
+// selectPaths selects dotted paths from a value. Since keys may contain dots
+// themselves (e.g. "rft.atitle"), the longest matching key wins. Arrays are
+// traversed, so a path like authors.name selects the name of each author.
+// Reports false, if nothing has been selected.
+func selectPaths(v interface{}, paths []string) (interface{}, bool) {
+	switch w := v.(type) {
+	case map[string]interface{}:
+		var (
+			rest  = make(map[string][]string) // remaining paths per key
+			whole = make(map[string]bool)     // keys selected as a whole
+		)
+		for _, p := range paths {
+			parts := strings.Split(p, ".")
+			for i := len(parts); i > 0; i-- {
+				k := strings.Join(parts[:i], ".")
+				if _, ok := w[k]; !ok {
+					continue
+				}
+				if i == len(parts) {
+					whole[k] = true
+				} else {
+					rest[k] = append(rest[k], strings.Join(parts[i:], "."))
+				}
+				break
+			}
+		}
+		out := make(map[string]interface{})
+		for k := range whole {
+			out[k] = w[k]
+		}
+		for k, ps := range rest {
+			if whole[k] {
+				continue
+			}
+			if x, ok := selectPaths(w[k], ps); ok {
+				out[k] = x
+			}
+		}
+		return out, len(out) > 0
+	case []interface{}:
+		out := make([]interface{}, 0, len(w))
+		for _, x := range w {
+			if y, ok := selectPaths(x, paths); ok {
+				out = append(out, y)
+			}
+		}
+		return out, len(out) > 0
+	default:
+		return nil, false
+	}
+}
+
+// ParseFields splits a comma separated list of dotted paths, like
+// "title,authors.name", as used in the fields query parameter.
+func ParseFields(s string) (fields []string) {
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// Project returns a JSON document, that only contains the given dotted paths
+// of a JSON object, e.g. "title" or "authors.name". Missing paths are left
+// out. Like stored documents, the result ends with a newline.
+func Project(doc []byte, fields []string) ([]byte, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("cannot project document: %v", err)
+	}
+	if _, ok := v.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("cannot project document: not a JSON object")
+	}
+	projected, _ := selectPaths(v, fields)
+	b, err := json.Marshal(projected)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
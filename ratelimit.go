@@ -0,0 +1,204 @@
+package microblob
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errRateLimited = errors.New("rate limit exceeded")
+	errBusy        = errors.New("too many requests in flight")
+)
+
+// maxBuckets is the number of clients tracked before idle ones are dropped.
+const maxBuckets = 10000
+
+// evictBuckets is the number of least recently seen clients dropped, if all
+// tracked clients are active.
+const evictBuckets = maxBuckets / 10
+
+// bucket is a token bucket for a single client.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter limits requests per client with a token bucket: each client
+// may do Rate requests per second on average and up to Burst at once.
+// Clients are identified by their credential, if authenticated, otherwise
+// by their IP address. Clients on a unix domain socket have no address and
+// share a single bucket, unless they authenticate.
+type RateLimiter struct {
+	Rate  float64
+	Burst int
+
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	rejected int64
+}
+
+// NewRateLimiter returns a limiter, burst defaults to the rate.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &RateLimiter{Rate: rate, Burst: burst, buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token for a client. If there is none, it reports how long to
+// wait for the next one.
+func (l *RateLimiter) Allow(client string) (bool, time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.sweep(now)
+		}
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	atomic.AddInt64(&l.rejected, 1)
+	return false, time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+// sweep drops buckets, that would be full by now, since they behave like new
+// ones. If that frees nothing, the least recently seen clients are dropped
+// and start over with a full bucket, which keeps memory bounded. Must be
+// called with the lock held.
+func (l *RateLimiter) sweep(now time.Time) {
+	refill := time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > refill {
+			delete(l.buckets, k)
+		}
+	}
+	if len(l.buckets) < maxBuckets {
+		return
+	}
+	keys := make([]string, 0, len(l.buckets))
+	for k := range l.buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return l.buckets[keys[i]].last.Before(l.buckets[keys[j]].last)
+	})
+	for _, k := range keys[:len(keys)-maxBuckets+evictBuckets] {
+		delete(l.buckets, k)
+	}
+}
+
+// Handler wraps a handler, that responds with 429 Too Many Requests, if the
+// client exceeds its rate.
+func (l *RateLimiter) Handler(h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.Allow(clientKey(r)); !ok {
+			writeTooManyRequests(w, wait, errRateLimited)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// InFlightLimiter limits the number of requests served at the same time.
+type InFlightLimiter struct {
+	sem      chan struct{}
+	rejected int64
+}
+
+// NewInFlightLimiter returns a limiter allowing at most n concurrent requests.
+func NewInFlightLimiter(n int) *InFlightLimiter {
+	return &InFlightLimiter{sem: make(chan struct{}, n)}
+}
+
+// Handler wraps a handler, that responds with 429 Too Many Requests, if the
+// limit is reached. Requests are rejected rather than queued, so a single
+// client cannot tie up file descriptors by piling up requests.
+func (l *InFlightLimiter) Handler(h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case l.sem <- struct{}{}:
+			defer func() { <-l.sem }()
+			h.ServeHTTP(w, r)
+		default:
+			atomic.AddInt64(&l.rejected, 1)
+			writeTooManyRequests(w, time.Second, errBusy)
+		}
+	})
+}
+
+// limitStats are reported in /stats.
+type limitStats struct {
+	RateLimit        float64 `json:"rate_limit,omitempty"`
+	RateBurst        int     `json:"rate_burst,omitempty"`
+	RateClients      int     `json:"rate_clients"`
+	RateLimited      int64   `json:"rate_limited"`
+	MaxInFlight      int     `json:"max_in_flight,omitempty"`
+	InFlight         int     `json:"in_flight"`
+	InFlightRejected int64   `json:"in_flight_rejected"`
+}
+
+// limitStatsOf collects statistics from the limiters, which may be nil.
+func limitStatsOf(rl *RateLimiter, fl *InFlightLimiter) limitStats {
+	var s limitStats
+	if rl != nil {
+		rl.mu.Lock()
+		s.RateClients = len(rl.buckets)
+		rl.mu.Unlock()
+		s.RateLimit, s.RateBurst = rl.Rate, rl.Burst
+		s.RateLimited = atomic.LoadInt64(&rl.rejected)
+	}
+	if fl != nil {
+		s.MaxInFlight, s.InFlight = cap(fl.sem), len(fl.sem)
+		s.InFlightRejected = atomic.LoadInt64(&fl.rejected)
+	}
+	return s
+}
+
+// clientKey identifies the client of a request by credential or IP address.
+// Peers on a unix domain socket are usually unnamed, with an empty or "@"
+// address, and are all keyed as "unix".
+func clientKey(r *http.Request) string {
+	if id := Identity(r); id != "" {
+		return "id:" + id
+	}
+	if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+		return "unix"
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// writeTooManyRequests responds with 429 and a Retry-After header in whole
+// seconds.
+func writeTooManyRequests(w http.ResponseWriter, wait time.Duration, err error) {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	writeError(w, http.StatusTooManyRequests, "", err)
+}
@@ -0,0 +1,44 @@
+package microblob
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestRateLimiterBucketsBounded(t *testing.T) {
+	// A rate this low keeps every bucket active during the test.
+	l := NewRateLimiter(0.001, 1)
+	for i := 0; i < maxBuckets+evictBuckets/2; i++ {
+		l.Allow("client-" + strconv.Itoa(i))
+	}
+	if n := len(l.buckets); n > maxBuckets {
+		t.Fatalf("got %d buckets, want at most %d", n, maxBuckets)
+	}
+	// The most recent client is still limited, the oldest one has been
+	// evicted.
+	if _, ok := l.buckets["client-0"]; ok {
+		t.Fatal("oldest client not evicted")
+	}
+	if ok, _ := l.Allow("client-" + strconv.Itoa(maxBuckets+evictBuckets/2-1)); ok {
+		t.Fatal("recent client not limited")
+	}
+}
+
+func TestClientKeyUnixSocket(t *testing.T) {
+	var cases = []struct {
+		remoteAddr string
+		want       string
+	}{
+		{"192.0.2.1:1234", "192.0.2.1"},
+		{"[2001:db8::1]:1234", "2001:db8::1"},
+		{"", "unix"},
+		{"@", "unix"},
+	}
+	for _, c := range cases {
+		r := &http.Request{RemoteAddr: c.remoteAddr}
+		if got := clientKey(r); got != c.want {
+			t.Errorf("clientKey(%q): got %q, want %q", c.remoteAddr, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,459 @@
+package microblob
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	maxRedisArgs        = 1 << 20  // arguments per command, at most
+	maxRedisCommandSize = 1 << 20  // bytes per command, at most, keys are short
+	maxRedisLine        = 64 << 10 // bytes per inline command or length line, like redis
+	defaultScanCount    = 10       // keys per SCAN call, like redis
+	maxRedisCursors     = 1024     // open SCAN cursors per connection
+)
+
+// RedisServer implements a read only subset of the redis protocol (RESP):
+// GET, MGET, EXISTS, DBSIZE, SCAN and INFO, plus PING and QUIT, so redis-cli
+// and redis client libraries can be used to query microblob.
+type RedisServer struct {
+	Backend Backend
+
+	started          time.Time
+	currConnections  int64
+	totalConnections int64
+	totalCommands    int64
+	keyspaceHits     int64
+	keyspaceMisses   int64
+}
+
+// redisWriteCommands are rejected with a read only error.
+var redisWriteCommands = map[string]bool{
+	"set": true, "setnx": true, "setex": true, "mset": true, "del": true,
+	"unlink": true, "append": true, "rename": true, "expire": true,
+	"flushdb": true, "flushall": true,
+}
+
+// redisConn holds the state of a single connection. Redis clients expect
+// numeric SCAN cursors, so they are mapped to the key to continue with.
+type redisConn struct {
+	br      *bufio.Reader
+	bw      *bufio.Writer
+	cursors map[uint64]string
+	next    uint64
+}
+
+// Serve accepts connections on a listener and serves each in a goroutine.
+func (s *RedisServer) Serve(ln net.Listener) error {
+	s.started = time.Now()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn handles commands on a single connection, until the client quits
+// or the connection fails.
+func (s *RedisServer) ServeConn(conn net.Conn) {
+	defer conn.Close()
+	atomic.AddInt64(&s.currConnections, 1)
+	atomic.AddInt64(&s.totalConnections, 1)
+	defer atomic.AddInt64(&s.currConnections, -1)
+	c := &redisConn{
+		br:      bufio.NewReaderSize(conn, maxRedisLine),
+		bw:      bufio.NewWriter(conn),
+		cursors: make(map[uint64]string),
+	}
+	for {
+		args, err := c.readCommand()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("redis: %v", err)
+				fmt.Fprintf(c.bw, "-ERR Protocol error: %v\r\n", err)
+				c.bw.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		atomic.AddInt64(&s.totalCommands, 1)
+		switch cmd := strings.ToLower(args[0]); {
+		case cmd == "get":
+			if len(args) != 2 {
+				c.wrongArgs(cmd)
+				break
+			}
+			b, err := s.document(args[1])
+			if err != nil {
+				c.writeError(err)
+				break
+			}
+			c.writeBulk(b)
+		case cmd == "mget":
+			if len(args) < 2 {
+				c.wrongArgs(cmd)
+				break
+			}
+			docs := make([][]byte, 0, len(args)-1)
+			for _, key := range args[1:] {
+				b, err := s.document(key)
+				if err != nil {
+					docs = nil
+					c.writeError(err)
+					break
+				}
+				docs = append(docs, b)
+			}
+			if docs == nil {
+				break
+			}
+			fmt.Fprintf(c.bw, "*%d\r\n", len(docs))
+			for _, b := range docs {
+				c.writeBulk(b)
+			}
+		case cmd == "exists":
+			if len(args) < 2 {
+				c.wrongArgs(cmd)
+				break
+			}
+			var n int
+			for _, key := range args[1:] {
+				ok, err := exists(s.Backend, key)
+				if err != nil {
+					n = -1
+					c.writeError(err)
+					break
+				}
+				if ok {
+					n++
+				}
+			}
+			if n >= 0 {
+				fmt.Fprintf(c.bw, ":%d\r\n", n)
+			}
+		case cmd == "dbsize":
+			counter, ok := s.Backend.(Counter)
+			if !ok {
+				c.bw.WriteString("-ERR backend does not support count\r\n")
+				break
+			}
+			n, err := counter.Count()
+			if err != nil {
+				c.writeError(err)
+				break
+			}
+			fmt.Fprintf(c.bw, ":%d\r\n", n)
+		case cmd == "scan":
+			s.scan(c, args[1:])
+		case cmd == "info":
+			s.info(c, args[1:])
+		case cmd == "ping":
+			if len(args) > 1 {
+				c.writeBulk([]byte(args[1]))
+			} else {
+				c.bw.WriteString("+PONG\r\n")
+			}
+		case cmd == "quit":
+			c.bw.WriteString("+OK\r\n")
+			c.bw.Flush()
+			return
+		case redisWriteCommands[cmd]:
+			c.bw.WriteString("-READONLY You can't write against a read only server.\r\n")
+		default:
+			fmt.Fprintf(c.bw, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		if c.br.Buffered() > 0 {
+			continue // pipelined commands, flush later
+		}
+		if err := c.bw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// document returns the document for a key, nil if the key does not exist.
+func (s *RedisServer) document(key string) ([]byte, error) {
+	entry, err := lookupEntry(context.Background(), s.Backend, key)
+	var b []byte
+	if err == nil {
+		b, err = readDocument(context.Background(), s.Backend, key, entry)
+	}
+	switch {
+	case err == nil:
+		atomic.AddInt64(&s.keyspaceHits, 1)
+		return b, nil
+	case errors.Is(err, ErrNotFound):
+		atomic.AddInt64(&s.keyspaceMisses, 1)
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// scan implements SCAN cursor [MATCH pattern] [COUNT count]. Patterns ending
+// in a single star, like "doi:*", are passed to the backend as prefix, other
+// patterns are matched against each key, so fewer keys than COUNT may be
+// returned, like in redis.
+func (s *RedisServer) scan(c *redisConn, args []string) {
+	scanner, ok := s.Backend.(Scanner)
+	if !ok {
+		c.bw.WriteString("-ERR backend does not support scan\r\n")
+		return
+	}
+	if len(args) == 0 || len(args)%2 != 1 {
+		c.wrongArgs("scan")
+		return
+	}
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		c.bw.WriteString("-ERR invalid cursor\r\n")
+		return
+	}
+	var (
+		opts    = ScanOptions{Limit: defaultScanCount}
+		pattern string
+	)
+	if cursor > 0 {
+		start, ok := c.cursors[cursor]
+		if !ok {
+			c.bw.WriteString("-ERR invalid cursor\r\n")
+			return
+		}
+		delete(c.cursors, cursor)
+		opts.Start = start
+	}
+	for i := 1; i < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 1 {
+				c.bw.WriteString("-ERR value is not an integer or out of range\r\n")
+				return
+			}
+			opts.Limit = n
+		default:
+			c.bw.WriteString("-ERR syntax error\r\n")
+			return
+		}
+	}
+	if p := strings.TrimSuffix(pattern, "*"); p != pattern && !strings.ContainsAny(p, `*?[\`) {
+		opts.Prefix, pattern = p, ""
+	}
+	if opts.Limit > maxKeysLimit {
+		opts.Limit = maxKeysLimit
+	}
+	var keys []string
+	next, err := scanner.Scan(opts, func(e Entry) error {
+		if pattern == "" || globMatch(pattern, e.Key) {
+			keys = append(keys, e.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		c.writeError(err)
+		return
+	}
+	cursor = 0
+	if next != "" {
+		if len(c.cursors) >= maxRedisCursors {
+			c.cursors = make(map[uint64]string) // abandoned scans
+		}
+		c.next++
+		cursor = c.next
+		c.cursors[cursor] = next
+	}
+	fmt.Fprintf(c.bw, "*2\r\n")
+	c.writeBulk([]byte(strconv.FormatUint(cursor, 10)))
+	fmt.Fprintf(c.bw, "*%d\r\n", len(keys))
+	for _, k := range keys {
+		c.writeBulk([]byte(k))
+	}
+}
+
+// info writes server information in the format of the redis INFO command. The
+// keyspace section requires a full count and is only included on request.
+func (s *RedisServer) info(c *redisConn, args []string) {
+	var (
+		now      = time.Now()
+		sb       strings.Builder
+		sections = make(map[string]bool)
+	)
+	for _, a := range args {
+		sections[strings.ToLower(a)] = true
+	}
+	all := len(sections) == 0 || sections["all"] || sections["default"] || sections["everything"]
+	if all || sections["server"] {
+		sb.WriteString("# Server\r\n")
+		fmt.Fprintf(&sb, "microblob_version:%s\r\n", Version)
+		fmt.Fprintf(&sb, "process_id:%d\r\n", os.Getpid())
+		fmt.Fprintf(&sb, "uptime_in_seconds:%d\r\n", int64(now.Sub(s.started).Seconds()))
+		sb.WriteString("\r\n")
+	}
+	if all || sections["clients"] {
+		sb.WriteString("# Clients\r\n")
+		fmt.Fprintf(&sb, "connected_clients:%d\r\n", atomic.LoadInt64(&s.currConnections))
+		sb.WriteString("\r\n")
+	}
+	if all || sections["stats"] {
+		sb.WriteString("# Stats\r\n")
+		fmt.Fprintf(&sb, "total_connections_received:%d\r\n", atomic.LoadInt64(&s.totalConnections))
+		fmt.Fprintf(&sb, "total_commands_processed:%d\r\n", atomic.LoadInt64(&s.totalCommands))
+		fmt.Fprintf(&sb, "keyspace_hits:%d\r\n", atomic.LoadInt64(&s.keyspaceHits))
+		fmt.Fprintf(&sb, "keyspace_misses:%d\r\n", atomic.LoadInt64(&s.keyspaceMisses))
+		sb.WriteString("\r\n")
+	}
+	if sections["keyspace"] || sections["everything"] {
+		sb.WriteString("# Keyspace\r\n")
+		if counter, ok := s.Backend.(Counter); ok {
+			n, err := counter.Count()
+			if err != nil {
+				c.writeError(err)
+				return
+			}
+			fmt.Fprintf(&sb, "db0:keys=%d,expires=0,avg_ttl=0\r\n", n)
+		}
+		sb.WriteString("\r\n")
+	}
+	c.writeBulk([]byte(sb.String()))
+}
+
+// readCommand reads a command, either as an array of bulk strings, as sent by
+// clients, or inline, as typed into a telnet session.
+func (c *redisConn) readCommand() ([]string, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < -1 || n > maxRedisArgs {
+		return nil, fmt.Errorf("invalid multibulk length")
+	}
+	// A null array (*-1) is an empty command. Arguments are collected as they
+	// arrive, the announced number is not trusted for allocation. All bytes
+	// read count against the command size, including the length lines.
+	var (
+		args []string
+		size = len(line) + 2
+	)
+	for i := 0; i < n; i++ {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected '$', got '%.1s'", line)
+		}
+		k, err := strconv.Atoi(line[1:])
+		if err != nil || k < 0 {
+			return nil, fmt.Errorf("invalid bulk length")
+		}
+		if size += len(line) + k + 4; size > maxRedisCommandSize {
+			return nil, fmt.Errorf("command too large")
+		}
+		b := make([]byte, k+2)
+		if _, err := io.ReadFull(c.br, b); err != nil {
+			return nil, err
+		}
+		args = append(args, string(b[:k]))
+	}
+	return args, nil
+}
+
+// readLine reads a line without the trailing CRLF. Lines are limited to the
+// size of the read buffer.
+func (c *redisConn) readLine() (string, error) {
+	line, err := c.br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("line too long")
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// writeBulk writes a bulk string, or a null bulk string for nil.
+func (c *redisConn) writeBulk(b []byte) {
+	if b == nil {
+		c.bw.WriteString("$-1\r\n")
+		return
+	}
+	fmt.Fprintf(c.bw, "$%d\r\n", len(b))
+	c.bw.Write(b)
+	c.bw.WriteString("\r\n")
+}
+
+// writeError writes a backend error, newlines would break the protocol.
+func (c *redisConn) writeError(err error) {
+	msg := strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error())
+	fmt.Fprintf(c.bw, "-ERR %s\r\n", msg)
+}
+
+// wrongArgs reports a wrong number of arguments for a command.
+func (c *redisConn) wrongArgs(cmd string) {
+	fmt.Fprintf(c.bw, "-ERR wrong number of arguments for '%s' command\r\n", cmd)
+}
+
+// globMatch reports whether a key matches a redis style glob pattern, with *
+// matching any sequence, ? a single byte and \ escaping the next byte.
+// Unlike path.Match, a star also matches slashes, which are common in keys.
+// On a mismatch, only the last star is retried with one more byte, so time
+// is bounded by the product of pattern and key length, for any number of
+// stars.
+func globMatch(pattern, key string) bool {
+	var (
+		p, k         int
+		starP, starK = -1, 0
+	)
+	for k < len(key) {
+		if p < len(pattern) {
+			switch c := pattern[p]; c {
+			case '*':
+				starP, starK = p, k
+				p++
+				continue
+			case '?':
+				p, k = p+1, k+1
+				continue
+			default:
+				n := 1
+				if c == '\\' && p+1 < len(pattern) {
+					c, n = pattern[p+1], 2
+				}
+				if key[k] == c {
+					p, k = p+n, k+1
+					continue
+				}
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		starK++
+		p, k = starP+1, starK
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
@@ -0,0 +1,90 @@
+package microblob
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// redisExchange sends raw protocol data to a RedisServer over an in-memory
+// connection and returns the first reply line.
+func redisExchange(t *testing.T, request string) string {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	s := &RedisServer{Backend: DebugBackend{Writer: io.Discard}}
+	go s.ServeConn(server)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	go func() {
+		io.WriteString(client, request)
+	}()
+	line, err := bufio.NewReader(client).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read reply: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+func TestRedisNegativeMultibulkLength(t *testing.T) {
+	var cases = []struct {
+		about   string
+		request string
+		want    string
+	}{
+		{"null array is an empty command", "*-1\r\n*1\r\n$4\r\nPING\r\n", "+PONG"},
+		{"negative length is rejected", "*-2\r\n", "-ERR Protocol error: invalid multibulk length"},
+		{"large length without data is not allocated", "*1048576\r\n$4\r\nPING\r\n$-1\r\n", "-ERR Protocol error: invalid bulk length"},
+		{"inline command", "PING\r\n", "+PONG"},
+	}
+	for _, c := range cases {
+		if got := redisExchange(t, c.request); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.about, got, c.want)
+		}
+	}
+}
+
+func TestRedisCommandLimits(t *testing.T) {
+	var cases = []struct {
+		about   string
+		request string
+		want    string
+	}{
+		{"long inline line", strings.Repeat("x", maxRedisLine) + "\r\n", "-ERR Protocol error: line too long"},
+		{"large bulk length", "*1\r\n$" + strconv.Itoa(maxRedisCommandSize) + "\r\n", "-ERR Protocol error: command too large"},
+		{"many arguments", "*1048576\r\n" + strings.Repeat("$1024\r\n"+strings.Repeat("k", 1024)+"\r\n", 1024), "-ERR Protocol error: command too large"},
+	}
+	for _, c := range cases {
+		if got := redisExchange(t, c.request); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.about, got, c.want)
+		}
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	var cases = []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"*", "", true},
+		{"a*", "abc", true},
+		{"*c", "abc", true},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"a*b*c", "a/x/b/y/c", true},
+		{"a*b*c", "a/x/c/y/b", false},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{`a\`, `a\`, true},
+		{"**a", "ba", true},
+		{strings.Repeat("*a", 20) + "*b", strings.Repeat("a", 100), false},
+	}
+	for _, c := range cases {
+		if got := globMatch(c.pattern, c.key); got != c.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", c.pattern, c.key, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// gRPC interface to microblob, mirrors the HTTP API.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: microblob.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Microblob_Get_FullMethodName      = "/microblob.Microblob/Get"
+	Microblob_BatchGet_FullMethodName = "/microblob.Microblob/BatchGet"
+	Microblob_Exists_FullMethodName   = "/microblob.Microblob/Exists"
+	Microblob_Count_FullMethodName    = "/microblob.Microblob/Count"
+	Microblob_Update_FullMethodName   = "/microblob.Microblob/Update"
+)
+
+// MicroblobClient is the client API for Microblob service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MicroblobClient interface {
+	// Get returns a single document.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Document, error)
+	// BatchGet streams documents for many keys, in request order. Missing keys
+	// are reported with found set to false.
+	BatchGet(ctx context.Context, in *BatchGetRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Document], error)
+	// Exists reports, which of the given keys are present.
+	Exists(ctx context.Context, in *ExistsRequest, opts ...grpc.CallOption) (*ExistsResponse, error)
+	// Count returns the number of keys.
+	Count(ctx context.Context, in *CountRequest, opts ...grpc.CallOption) (*CountResponse, error)
+	// Update appends newline delimited documents, sent in chunks. The first
+	// message must name the key field, like the key parameter of /update.
+	Update(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UpdateRequest, UpdateResponse], error)
+}
+
+type microblobClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMicroblobClient(cc grpc.ClientConnInterface) MicroblobClient {
+	return &microblobClient{cc}
+}
+
+func (c *microblobClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*Document, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Document)
+	err := c.cc.Invoke(ctx, Microblob_Get_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *microblobClient) BatchGet(ctx context.Context, in *BatchGetRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Document], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Microblob_ServiceDesc.Streams[0], Microblob_BatchGet_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[BatchGetRequest, Document]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Microblob_BatchGetClient = grpc.ServerStreamingClient[Document]
+
+func (c *microblobClient) Exists(ctx context.Context, in *ExistsRequest, opts ...grpc.CallOption) (*ExistsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ExistsResponse)
+	err := c.cc.Invoke(ctx, Microblob_Exists_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *microblobClient) Count(ctx context.Context, in *CountRequest, opts ...grpc.CallOption) (*CountResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CountResponse)
+	err := c.cc.Invoke(ctx, Microblob_Count_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *microblobClient) Update(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[UpdateRequest, UpdateResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Microblob_ServiceDesc.Streams[1], Microblob_Update_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[UpdateRequest, UpdateResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Microblob_UpdateClient = grpc.ClientStreamingClient[UpdateRequest, UpdateResponse]
+
+// MicroblobServer is the server API for Microblob service.
+// All implementations must embed UnimplementedMicroblobServer
+// for forward compatibility.
+type MicroblobServer interface {
+	// Get returns a single document.
+	Get(context.Context, *GetRequest) (*Document, error)
+	// BatchGet streams documents for many keys, in request order. Missing keys
+	// are reported with found set to false.
+	BatchGet(*BatchGetRequest, grpc.ServerStreamingServer[Document]) error
+	// Exists reports, which of the given keys are present.
+	Exists(context.Context, *ExistsRequest) (*ExistsResponse, error)
+	// Count returns the number of keys.
+	Count(context.Context, *CountRequest) (*CountResponse, error)
+	// Update appends newline delimited documents, sent in chunks. The first
+	// message must name the key field, like the key parameter of /update.
+	Update(grpc.ClientStreamingServer[UpdateRequest, UpdateResponse]) error
+	mustEmbedUnimplementedMicroblobServer()
+}
+
+// UnimplementedMicroblobServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMicroblobServer struct{}
+
+func (UnimplementedMicroblobServer) Get(context.Context, *GetRequest) (*Document, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedMicroblobServer) BatchGet(*BatchGetRequest, grpc.ServerStreamingServer[Document]) error {
+	return status.Errorf(codes.Unimplemented, "method BatchGet not implemented")
+}
+func (UnimplementedMicroblobServer) Exists(context.Context, *ExistsRequest) (*ExistsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Exists not implemented")
+}
+func (UnimplementedMicroblobServer) Count(context.Context, *CountRequest) (*CountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Count not implemented")
+}
+func (UnimplementedMicroblobServer) Update(grpc.ClientStreamingServer[UpdateRequest, UpdateResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method Update not implemented")
+}
+func (UnimplementedMicroblobServer) mustEmbedUnimplementedMicroblobServer() {}
+func (UnimplementedMicroblobServer) testEmbeddedByValue()                   {}
+
+// UnsafeMicroblobServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MicroblobServer will
+// result in compilation errors.
+type UnsafeMicroblobServer interface {
+	mustEmbedUnimplementedMicroblobServer()
+}
+
+func RegisterMicroblobServer(s grpc.ServiceRegistrar, srv MicroblobServer) {
+	// If the following call pancis, it indicates UnimplementedMicroblobServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Microblob_ServiceDesc, srv)
+}
+
+func _Microblob_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MicroblobServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Microblob_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MicroblobServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Microblob_BatchGet_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BatchGetRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MicroblobServer).BatchGet(m, &grpc.GenericServerStream[BatchGetRequest, Document]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Microblob_BatchGetServer = grpc.ServerStreamingServer[Document]
+
+func _Microblob_Exists_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExistsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MicroblobServer).Exists(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Microblob_Exists_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MicroblobServer).Exists(ctx, req.(*ExistsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Microblob_Count_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MicroblobServer).Count(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Microblob_Count_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MicroblobServer).Count(ctx, req.(*CountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Microblob_Update_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MicroblobServer).Update(&grpc.GenericServerStream[UpdateRequest, UpdateResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Microblob_UpdateServer = grpc.ClientStreamingServer[UpdateRequest, UpdateResponse]
+
+// Microblob_ServiceDesc is the grpc.ServiceDesc for Microblob service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Microblob_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "microblob.Microblob",
+	HandlerType: (*MicroblobServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _Microblob_Get_Handler,
+		},
+		{
+			MethodName: "Exists",
+			Handler:    _Microblob_Exists_Handler,
+		},
+		{
+			MethodName: "Count",
+			Handler:    _Microblob_Count_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "BatchGet",
+			Handler:       _Microblob_BatchGet_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Update",
+			Handler:       _Microblob_Update_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "microblob.proto",
+}
@@ -1,30 +1,99 @@
 package microblob
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/miku/microblob/api"
 	"github.com/segmentio/encoding/json"
 	"github.com/thoas/stats"
 )
 
+// HandlerOptions configures the handler returned by NewHandlerOptions.
+type HandlerOptions struct {
+	CacheControl string // Cache-Control header value for documents, e.g. "public, max-age=3600"
+	Auth         *Auth  // credentials for write and optionally read routes, nil for no auth
+
+	// RequireClientCert restricts write routes to clients with a verified TLS
+	// client certificate.
+	RequireClientCert bool
+
+	ReadOnly    bool    // do not register write routes
+	RateLimit   float64 // requests per second and client, zero means no limit
+	RateBurst   int     // requests a client may do at once, defaults to RateLimit
+	MaxInFlight int     // concurrent document requests, zero means no limit
+
+	// ReadyKeys are looked up and read on every readiness probe.
+	ReadyKeys []string
+
+	HotKeys       int     // number of hot keys to track, zero disables tracking
+	HotKeysSample float64 // fraction of requests sampled for hot keys
+}
+
 // NewHandler sets up routes for serving and stats.
 func NewHandler(backend Backend, blobfile string) http.Handler {
+	return NewHandlerOptions(backend, blobfile, HandlerOptions{})
+}
+
+// NewHandlerOptions sets up routes for serving and stats, with options.
+func NewHandlerOptions(backend Backend, blobfile string, opts HandlerOptions) http.Handler {
+	var (
+		rateLimiter     *RateLimiter
+		inFlightLimiter *InFlightLimiter
+		hotKeys         *HotKeys
+	)
+	if opts.RateLimit > 0 {
+		rateLimiter = NewRateLimiter(opts.RateLimit, opts.RateBurst)
+	}
+	if opts.MaxInFlight > 0 {
+		inFlightLimiter = NewInFlightLimiter(opts.MaxInFlight)
+	}
+	if opts.HotKeys > 0 {
+		hotKeys = NewHotKeys(opts.HotKeys, opts.HotKeysSample)
+	}
+	// Rate limits apply after authentication, so clients are identified by
+	// their credentials, if possible. Metrics include rejected requests.
+	var (
+		read = func(route string, h http.Handler) http.Handler {
+			return instrument(route, opts.Auth.Require(ScopeRead, rateLimiter.Handler(h)))
+		}
+		write = func(route string, h http.Handler) http.Handler {
+			h = opts.Auth.Require(ScopeWrite, rateLimiter.Handler(h))
+			if opts.RequireClientCert {
+				h = RequireClientCert(h)
+			}
+			return instrument(route, h)
+		}
+	)
 	metrics := stats.New()
-	blobHandler := metrics.Handler(
+	blobHandler := read("blob", inFlightLimiter.Handler(metrics.Handler(
 		WithLastResponseTime(
-			&BlobHandler{Backend: backend}))
+			WithCompression(
+				&BlobHandler{Backend: backend, CacheControl: opts.CacheControl, HotKeys: hotKeys})))))
 
 	r := mux.NewRouter()
-	r.Handle("/debug/vars", http.DefaultServeMux)
-	r.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+	// Probes are neither authenticated nor rate limited.
+	r.Handle(api.RouteHealthz, instrument("healthz", http.HandlerFunc(healthHandler)))
+	r.Handle(api.RouteReadyz, instrument("readyz", ReadyHandler{Backend: backend, Keys: opts.ReadyKeys}))
+	r.Handle(api.RouteVars, read("vars", http.DefaultServeMux))
+	if hotKeys != nil {
+		// Keys may be sensitive, so this requires write access, if configured.
+		r.Handle(api.RouteHotKeys, instrument("hotkeys", opts.Auth.Require(ScopeWrite, hotKeys)))
+	}
+	r.Handle(api.RouteMetrics, read("metrics", newMetricsHandler(backend)))
+	r.Handle(api.RouteStats, read("stats", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		if err := json.NewEncoder(w).Encode(metrics.Data()); err != nil {
+		data := struct {
+			*stats.Data
+			Limits limitStats `json:"limits"`
+		}{metrics.Data(), limitStatsOf(rateLimiter, inFlightLimiter)}
+		if err := json.NewEncoder(w).Encode(data); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-	})
+	})))
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(map[string]interface{}{
@@ -32,29 +101,47 @@ func NewHandler(backend Backend, blobfile string) http.Handler {
 			"version": Version,
 			"stats":   fmt.Sprintf("http://%s/stats", r.Host),
 			"vars":    fmt.Sprintf("http://%s/debug/vars", r.Host),
+			"metrics": fmt.Sprintf("http://%s/metrics", r.Host),
+			"info":    fmt.Sprintf("http://%s/info", r.Host),
+			"ready":   fmt.Sprintf("http://%s/readyz", r.Host),
 		}); err != nil {
-			http.Error(w, "could not serialize", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, "", fmt.Errorf("could not serialize: %w", err))
 			return
 		}
 	})
-	r.HandleFunc("/count", func(w http.ResponseWriter, r *http.Request) {
-		if c, ok := backend.(Counter); ok {
-			count, err := c.Count()
-			if err != nil {
-				http.Error(w, fmt.Sprintf("count failed: %s", err), http.StatusInternalServerError)
-				return
-			}
-			if err := json.NewEncoder(w).Encode(map[string]int64{"count": count}); err != nil {
-				http.Error(w, "could not serialize", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			http.Error(w, "not implemented", http.StatusNotFound)
+	r.Handle(api.RouteInfo, read("info", InfoHandler{Backend: backend}))
+	r.Handle(api.RouteCount, read("count", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, ok := backend.(Counter)
+		if !ok {
+			writeError(w, http.StatusNotImplemented, "", errors.New("backend does not support count"))
 			return
 		}
-	})
-	r.Handle("/update", UpdateHandler{Backend: backend, Blobfile: blobfile})
-	r.Handle("/blob", blobHandler)     // Legacy route.
-	r.Handle("/{key:.+}", blobHandler) // Preferred.
-	return r
+		count, err := c.Count()
+		if err != nil {
+			writeBackendError(w, r, "", fmt.Errorf("count failed: %w", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]int64{"count": count}); err != nil {
+			writeError(w, http.StatusInternalServerError, "", fmt.Errorf("could not serialize: %w", err))
+			return
+		}
+	})))
+	r.Handle(api.RouteExists, read("exists", WithCompression(ExistsHandler{Backend: backend})))
+	r.Methods("GET", "HEAD").Path(api.RouteKeys).Handler(read("keys", WithCompression(KeysHandler{Backend: backend})))
+	r.Methods("GET").Path(api.RouteExport).Handler(read("export", WithCompression(ExportHandler{Backend: backend})))
+	if opts.ReadOnly {
+		// Keep PUT from falling through to lookups.
+		r.Methods("PUT").Path("/{key:.+}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeError(w, http.StatusMethodNotAllowed, "", ErrReadOnly)
+		})
+	} else {
+		r.Handle(api.RouteUpdate, write("update", UpdateHandler{Backend: backend, Blobfile: blobfile}))
+		r.Methods("PUT").Path("/{key:.+}").Handler(write("put", PutHandler{Backend: backend, Blobfile: blobfile}))
+	}
+	r.Handle(api.RouteBlob, blobHandler)   // Legacy route.
+	r.Handle(api.RouteLookup, blobHandler) // Escaped keys, that do not fit the preferred route.
+	r.Handle("/{key:.+}", blobHandler)     // Preferred.
+	return WithTraceContext(r)
 }
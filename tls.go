@@ -0,0 +1,90 @@
+package microblob
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+var errClientCert = errors.New("client certificate required")
+
+// TLSReloader keeps a TLS configuration, that can be reloaded from files, e.g.
+// on SIGHUP, without restarting the server. If a client CA file is given,
+// client certificates are verified, if presented; RequireClientCert decides,
+// which routes need one.
+type TLSReloader struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+
+	mu     sync.RWMutex
+	config *tls.Config
+}
+
+// NewTLSReloader loads certificate, key and optional client CAs.
+func NewTLSReloader(certFile, keyFile, clientCAFile string) (*TLSReloader, error) {
+	t := &TLSReloader{CertFile: certFile, KeyFile: keyFile, ClientCAFile: clientCAFile}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reload reads the files again. On error, the previous configuration stays
+// in place.
+func (t *TLSReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if t.ClientCAFile != "" {
+		b, err := ioutil.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("tls: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("tls: no certificates found in %s", t.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	t.mu.Lock()
+	t.config = config
+	t.mu.Unlock()
+	return nil
+}
+
+// Config returns a configuration for a listener, which uses the most recently
+// loaded certificates for each new connection.
+func (t *TLSReloader) Config() *tls.Config {
+	return &tls.Config{
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			t.mu.RLock()
+			defer t.mu.RUnlock()
+			return t.config, nil
+		},
+	}
+}
+
+// RequireClientCert wraps a handler, that may only be used with a verified
+// TLS client certificate.
+func RequireClientCert(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			writeError(w, http.StatusForbidden, "", errClientCert)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}